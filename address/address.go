@@ -0,0 +1,222 @@
+package address
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type Address struct {
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError []FieldError
+
+type countryRule struct {
+	Name           string
+	PostalCode     *regexp.Regexp
+	PostalExample  string
+	FormatPostal   func(compact string) string
+	RegionRequired bool
+}
+
+var countryRules = map[string]countryRule{
+	"AU": {Name: "Australia", PostalCode: regexp.MustCompile(`^\d{4}$`), PostalExample: "2000", RegionRequired: true},
+	"CA": {Name: "Canada", PostalCode: regexp.MustCompile(`^[A-Z]\d[A-Z]\d[A-Z]\d$`), PostalExample: "K1A 0B1", FormatPostal: splitPostalCode(3, " "), RegionRequired: true},
+	"DE": {Name: "Germany", PostalCode: regexp.MustCompile(`^\d{5}$`), PostalExample: "10115"},
+	"FR": {Name: "France", PostalCode: regexp.MustCompile(`^\d{5}$`), PostalExample: "75001"},
+	"GB": {Name: "United Kingdom", PostalCode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]?\d[A-Z]{2}$`), PostalExample: "SW1A 1AA", FormatPostal: splitPostalCode(-3, " ")},
+	"ID": {Name: "Indonesia", PostalCode: regexp.MustCompile(`^\d{5}$`), PostalExample: "10110"},
+	"JP": {Name: "Japan", PostalCode: regexp.MustCompile(`^\d{7}$`), PostalExample: "100-0001", FormatPostal: splitPostalCode(3, "-")},
+	"NL": {Name: "Netherlands", PostalCode: regexp.MustCompile(`^\d{4}[A-Z]{2}$`), PostalExample: "1011 AB", FormatPostal: splitPostalCode(4, " ")},
+	"SG": {Name: "Singapore", PostalCode: regexp.MustCompile(`^\d{6}$`), PostalExample: "018956"},
+	"US": {Name: "United States", PostalCode: regexp.MustCompile(`^\d{5}(\d{4})?$`), PostalExample: "94105", FormatPostal: splitPostalCode(5, "-"), RegionRequired: true},
+}
+
+var countryAliases = map[string]string{
+	"AMERICA":                  "US",
+	"DEUTSCHLAND":              "DE",
+	"ENGLAND":                  "GB",
+	"GREAT BRITAIN":            "GB",
+	"HOLLAND":                  "NL",
+	"UK":                       "GB",
+	"UNITED STATES OF AMERICA": "US",
+	"USA":                      "US",
+}
+
+var abbreviations = map[string]string{
+	"APARTMENT": "Apt",
+	"APT":       "Apt",
+	"AVE":       "Ave",
+	"AVENUE":    "Ave",
+	"BLDG":      "Bldg",
+	"BLVD":      "Blvd",
+	"BOULEVARD": "Blvd",
+	"BUILDING":  "Bldg",
+	"COURT":     "Ct",
+	"CT":        "Ct",
+	"DR":        "Dr",
+	"DRIVE":     "Dr",
+	"FL":        "Fl",
+	"FLOOR":     "Fl",
+	"HIGHWAY":   "Hwy",
+	"HWY":       "Hwy",
+	"LANE":      "Ln",
+	"LN":        "Ln",
+	"PL":        "Pl",
+	"PLACE":     "Pl",
+	"RD":        "Rd",
+	"ROAD":      "Rd",
+	"ST":        "St",
+	"STE":       "Ste",
+	"STREET":    "St",
+	"SUITE":     "Ste",
+}
+
+func Normalize(address Address) (Address, error) {
+	var errs ValidationError
+
+	address.Line1 = normalizeWords(address.Line1, true)
+	address.Line2 = normalizeWords(address.Line2, true)
+	address.City = normalizeWords(address.City, false)
+	if address.Line1 == "" {
+		errs = append(errs, FieldError{Field: "line1", Message: "is required"})
+	}
+	if address.City == "" {
+		errs = append(errs, FieldError{Field: "city", Message: "is required"})
+	}
+
+	country, ok := ResolveCountry(address.Country)
+	if !ok {
+		if strings.TrimSpace(address.Country) == "" {
+			errs = append(errs, FieldError{Field: "country", Message: "is required"})
+		} else {
+			errs = append(errs, FieldError{Field: "country", Message: fmt.Sprintf("%q is not a supported country", address.Country)})
+		}
+		address.Region = normalizeWords(address.Region, false)
+		address.PostalCode = strings.ToUpper(strings.Join(strings.Fields(address.PostalCode), " "))
+		return address, errs
+	}
+	address.Country = country
+	rule := countryRules[country]
+
+	if rule.RegionRequired {
+		address.Region = strings.ToUpper(strings.Join(strings.Fields(address.Region), ""))
+		switch {
+		case address.Region == "":
+			errs = append(errs, FieldError{Field: "region", Message: fmt.Sprintf("is required for %s", rule.Name)})
+		case len(address.Region) < 2 || len(address.Region) > 3:
+			errs = append(errs, FieldError{Field: "region", Message: fmt.Sprintf("%q must be a 2 or 3 letter state or province code", address.Region)})
+		}
+	} else {
+		address.Region = normalizeWords(address.Region, false)
+	}
+
+	compact := strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(address.PostalCode))
+	switch {
+	case compact == "":
+		errs = append(errs, FieldError{Field: "postal_code", Message: "is required"})
+	case !rule.PostalCode.MatchString(compact):
+		errs = append(errs, FieldError{Field: "postal_code", Message: fmt.Sprintf("%q is not a valid %s postal code (for example %s)", strings.TrimSpace(address.PostalCode), rule.Name, rule.PostalExample)})
+	default:
+		if rule.FormatPostal != nil {
+			compact = rule.FormatPostal(compact)
+		}
+		address.PostalCode = compact
+	}
+
+	if len(errs) > 0 {
+		return address, errs
+	}
+	return address, nil
+}
+
+func ResolveCountry(value string) (string, bool) {
+	key := strings.ToUpper(strings.Join(strings.Fields(value), " "))
+	if _, ok := countryRules[key]; ok {
+		return key, true
+	}
+	if code, ok := countryAliases[key]; ok {
+		return code, true
+	}
+	for code, rule := range countryRules {
+		if strings.ToUpper(rule.Name) == key {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+func Supported(country string) bool {
+	_, ok := countryRules[country]
+	return ok
+}
+
+func normalizeWords(value string, abbreviate bool) string {
+	words := strings.Fields(value)
+	for i, word := range words {
+		if abbreviate {
+			if abbreviation, ok := abbreviations[strings.ToUpper(strings.TrimSuffix(word, "."))]; ok {
+				words[i] = abbreviation
+				continue
+			}
+		}
+		if word != strings.ToUpper(word) && word != strings.ToLower(word) {
+			continue
+		}
+		parts := strings.Split(word, "-")
+		for j, part := range parts {
+			runes := []rune(strings.ToLower(part))
+			if len(runes) == 0 || unicode.IsDigit(runes[0]) {
+				parts[j] = string(runes)
+				continue
+			}
+			runes[0] = unicode.ToUpper(runes[0])
+			parts[j] = string(runes)
+		}
+		words[i] = strings.Join(parts, "-")
+	}
+	return strings.Join(words, " ")
+}
+
+func splitPostalCode(at int, separator string) func(string) string {
+	return func(compact string) string {
+		position := at
+		if position < 0 {
+			position += len(compact)
+		}
+		if position <= 0 || position >= len(compact) {
+			return compact
+		}
+		return compact[:position] + separator + compact[position:]
+	}
+}
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		messages = append(messages, fieldErr.Field+" "+fieldErr.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (a Address) String() string {
+	var parts []string
+	for _, part := range []string{a.Line1, a.Line2, a.City, strings.TrimSpace(a.Region + " " + a.PostalCode), a.Country} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/money"
+)
+
+const (
+	StatusPending    = "PENDING"
+	StatusAuthorized = "AUTHORIZED"
+	StatusCaptured   = "CAPTURED"
+	StatusRefunded   = "REFUNDED"
+	StatusVoided     = "VOIDED"
+	StatusDeclined   = "DECLINED"
+)
+
+const (
+	BehaviorApprove = "approve"
+	BehaviorDecline = "decline"
+	BehaviorTimeout = "timeout"
+	BehaviorSlow    = "slow"
+	BehaviorPending = "pending"
+)
+
+const (
+	MaxTimeoutDelay       = 60 * time.Second
+	DefaultSettlementWait = 2 * time.Second
+)
+
+type Money = money.Money
+
+type GatewayTransaction struct {
+	ID             string    `json:"id"`
+	Reference      string    `json:"reference"`
+	Amount         Money     `json:"amount"`
+	CapturedAmount Money     `json:"captured_amount"`
+	RefundedAmount Money     `json:"refunded_amount"`
+	Status         string    `json:"status"`
+	CallbackURL    string    `json:"callback_url,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type ScriptRule struct {
+	Operation string `json:"operation,omitempty"`
+	Behavior  string `json:"behavior"`
+	DelayMs   int    `json:"delay_ms,omitempty"`
+	Times     int    `json:"times,omitempty"`
+	Outcome   string `json:"outcome,omitempty"`
+}
+
+type GatewayRequest struct {
+	TransactionID string `json:"transaction_id"`
+	Reference     string `json:"reference"`
+	Amount        *Money `json:"amount"`
+	CallbackURL   string `json:"callback_url"`
+}
+
+type GatewayResponse struct {
+	TransactionID  string `json:"transaction_id,omitempty"`
+	Reference      string `json:"reference,omitempty"`
+	Status         string `json:"status"`
+	Amount         Money  `json:"amount"`
+	RefundedAmount Money  `json:"refunded_amount"`
+	Message        string `json:"message,omitempty"`
+}
+
+var (
+	transactions = make(map[string]GatewayTransaction)
+	script       []ScriptRule
+	mu           sync.Mutex
+	nextID       = 1
+)
+
+func main() {
+	http.HandleFunc("/authorize", authorizeHandler)
+	http.HandleFunc("/capture", captureHandler)
+	http.HandleFunc("/refund", refundHandler)
+	http.HandleFunc("/void", voidHandler)
+	http.HandleFunc("/query", queryHandler)
+	http.HandleFunc("/script", scriptHandler)
+	http.HandleFunc("/reset-script", resetScriptHandler)
+
+	fmt.Println("Fake Payment Gateway started on :8090")
+	log.Fatal(http.ListenAndServe(":8090", nil))
+}
+
+func authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeGatewayRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Amount == nil || !req.Amount.IsPositive() {
+		http.Error(w, "Amount must be greater than zero", http.StatusBadRequest)
+		return
+	}
+	if err := req.Amount.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid amount: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	behavior := nextBehavior("authorize")
+
+	mu.Lock()
+	transactionID := fmt.Sprintf("GTX-%d", nextID)
+	nextID++
+
+	status := StatusAuthorized
+	switch behavior.Behavior {
+	case BehaviorDecline:
+		status = StatusDeclined
+	case BehaviorPending:
+		status = StatusPending
+	}
+
+	transaction := GatewayTransaction{
+		ID:             transactionID,
+		Reference:      req.Reference,
+		Amount:         *req.Amount,
+		CapturedAmount: Money{Currency: req.Amount.Currency},
+		RefundedAmount: Money{Currency: req.Amount.Currency},
+		Status:         status,
+		CallbackURL:    req.CallbackURL,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	transactions[transactionID] = transaction
+	mu.Unlock()
+
+	fmt.Printf("Authorize %s (%s) for %s: %s\n", transactionID, req.Reference, req.Amount, behavior.Behavior)
+
+	if status == StatusPending {
+		go settleLater(transactionID, behavior)
+		writeGatewayResponse(w, http.StatusAccepted, transaction, "Authorization pending")
+		return
+	}
+
+	if !applyDelay(r, behavior) {
+		return
+	}
+
+	if status == StatusDeclined {
+		writeGatewayResponse(w, http.StatusPaymentRequired, transaction, "Authorization declined")
+		return
+	}
+	writeGatewayResponse(w, http.StatusOK, transaction, "Authorization approved")
+}
+
+func settleLater(transactionID string, behavior ScriptRule) {
+	wait := DefaultSettlementWait
+	if behavior.DelayMs > 0 {
+		wait = time.Duration(behavior.DelayMs) * time.Millisecond
+	}
+	time.Sleep(wait)
+
+	mu.Lock()
+	transaction, exists := transactions[transactionID]
+	if !exists || transaction.Status != StatusPending {
+		mu.Unlock()
+		return
+	}
+
+	transaction.Status = StatusAuthorized
+	if behavior.Outcome == BehaviorDecline {
+		transaction.Status = StatusDeclined
+	}
+	transaction.UpdatedAt = time.Now()
+	transactions[transactionID] = transaction
+	mu.Unlock()
+
+	fmt.Printf("Settled %s (%s): %s\n", transactionID, transaction.Reference, transaction.Status)
+
+	if transaction.CallbackURL == "" {
+		return
+	}
+
+	callback := GatewayResponse{
+		TransactionID: transaction.ID,
+		Reference:     transaction.Reference,
+		Status:        transaction.Status,
+		Amount:        transaction.Amount,
+	}
+	reqBody, err := json.Marshal(callback)
+	if err != nil {
+		return
+	}
+
+	resp, err := http.Post(transaction.CallbackURL, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Printf("Callback for %s failed: %v\n", transactionID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func captureHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeGatewayRequest(w, r)
+	if !ok {
+		return
+	}
+
+	behavior := nextBehavior("capture")
+	transaction, ok := updateTransaction(w, req.TransactionID, behavior, func(t *GatewayTransaction) string {
+		if t.Status != StatusAuthorized {
+			return fmt.Sprintf("Cannot capture transaction in status %s", t.Status)
+		}
+		amount := t.Amount
+		if req.Amount != nil {
+			amount = *req.Amount
+		}
+		if cmp, err := amount.Cmp(t.Amount); err != nil {
+			return err.Error()
+		} else if cmp > 0 {
+			return "Capture amount exceeds authorized amount"
+		}
+		t.CapturedAmount = amount
+		t.Status = StatusCaptured
+		return ""
+	})
+	if !ok {
+		return
+	}
+
+	if !applyDelay(r, behavior) {
+		return
+	}
+	writeGatewayResponse(w, http.StatusOK, transaction, "Capture approved")
+}
+
+func refundHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeGatewayRequest(w, r)
+	if !ok {
+		return
+	}
+
+	behavior := nextBehavior("refund")
+	transaction, ok := updateTransaction(w, req.TransactionID, behavior, func(t *GatewayTransaction) string {
+		if t.Status != StatusCaptured {
+			return fmt.Sprintf("Cannot refund transaction in status %s", t.Status)
+		}
+		remaining, _ := t.CapturedAmount.Sub(t.RefundedAmount)
+		amount := remaining
+		if req.Amount != nil {
+			amount = *req.Amount
+		}
+		if cmp, err := amount.Cmp(remaining); err != nil {
+			return err.Error()
+		} else if cmp > 0 {
+			return "Refund amount exceeds captured amount"
+		}
+		t.RefundedAmount, _ = t.RefundedAmount.Add(amount)
+		if t.RefundedAmount == t.CapturedAmount {
+			t.Status = StatusRefunded
+		}
+		return ""
+	})
+	if !ok {
+		return
+	}
+
+	if !applyDelay(r, behavior) {
+		return
+	}
+	writeGatewayResponse(w, http.StatusOK, transaction, "Refund approved")
+}
+
+func voidHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeGatewayRequest(w, r)
+	if !ok {
+		return
+	}
+
+	behavior := nextBehavior("void")
+	transaction, ok := updateTransaction(w, req.TransactionID, behavior, func(t *GatewayTransaction) string {
+		if t.Status != StatusAuthorized && t.Status != StatusPending {
+			return fmt.Sprintf("Cannot void transaction in status %s", t.Status)
+		}
+		t.Status = StatusVoided
+		return ""
+	})
+	if !ok {
+		return
+	}
+
+	if !applyDelay(r, behavior) {
+		return
+	}
+	writeGatewayResponse(w, http.StatusOK, transaction, "Void approved")
+}
+
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transactionID := r.URL.Query().Get("transaction_id")
+	reference := r.URL.Query().Get("reference")
+	if transactionID == "" && reference == "" {
+		http.Error(w, "Transaction ID or reference is required", http.StatusBadRequest)
+		return
+	}
+
+	behavior := nextBehavior("query")
+
+	mu.Lock()
+	var transaction GatewayTransaction
+	var found bool
+	if transactionID != "" {
+		transaction, found = transactions[transactionID]
+	} else {
+		for _, t := range transactions {
+			if t.Reference == reference && (!found || t.CreatedAt.After(transaction.CreatedAt)) {
+				transaction = t
+				found = true
+			}
+		}
+	}
+	mu.Unlock()
+
+	if !applyDelay(r, behavior) {
+		return
+	}
+	if !found {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	writeGatewayResponse(w, http.StatusOK, transaction, "")
+}
+
+func scriptHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		mu.Lock()
+		rules := append([]ScriptRule{}, script...)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	case http.MethodPost:
+		var rules []ScriptRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for _, rule := range rules {
+			switch rule.Behavior {
+			case BehaviorApprove, BehaviorDecline, BehaviorTimeout, BehaviorSlow, BehaviorPending:
+			default:
+				http.Error(w, fmt.Sprintf("Unknown behavior: %s", rule.Behavior), http.StatusBadRequest)
+				return
+			}
+		}
+
+		mu.Lock()
+		script = append(script, rules...)
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+		fmt.Printf("Script updated with %d rule(s)\n", len(rules))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func resetScriptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.Lock()
+	script = nil
+	mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+	fmt.Println("Script reset")
+}
+
+func decodeGatewayRequest(w http.ResponseWriter, r *http.Request) (GatewayRequest, bool) {
+	var req GatewayRequest
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func updateTransaction(w http.ResponseWriter, transactionID string, behavior ScriptRule, apply func(t *GatewayTransaction) string) (GatewayTransaction, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	transaction, exists := transactions[transactionID]
+	if !exists {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return transaction, false
+	}
+
+	if behavior.Behavior == BehaviorDecline {
+		writeGatewayResponse(w, http.StatusPaymentRequired, transaction, "Operation declined")
+		return transaction, false
+	}
+
+	if msg := apply(&transaction); msg != "" {
+		writeGatewayResponse(w, http.StatusConflict, transaction, msg)
+		return transaction, false
+	}
+	transaction.UpdatedAt = time.Now()
+	transactions[transactionID] = transaction
+	return transaction, true
+}
+
+func nextBehavior(operation string) ScriptRule {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i, rule := range script {
+		if rule.Operation != "" && rule.Operation != operation {
+			continue
+		}
+		if rule.Times > 0 {
+			rule.Times--
+			if rule.Times == 0 {
+				script = append(script[:i], script[i+1:]...)
+			} else {
+				script[i] = rule
+			}
+		}
+		return rule
+	}
+	return ScriptRule{Behavior: BehaviorApprove}
+}
+
+func applyDelay(r *http.Request, behavior ScriptRule) bool {
+	switch behavior.Behavior {
+	case BehaviorSlow:
+		select {
+		case <-time.After(time.Duration(behavior.DelayMs) * time.Millisecond):
+			return true
+		case <-r.Context().Done():
+			return false
+		}
+	case BehaviorTimeout:
+		select {
+		case <-time.After(MaxTimeoutDelay):
+		case <-r.Context().Done():
+		}
+		return false
+	}
+	return true
+}
+
+func writeGatewayResponse(w http.ResponseWriter, statusCode int, transaction GatewayTransaction, message string) {
+	resp := GatewayResponse{
+		TransactionID:  transaction.ID,
+		Reference:      transaction.Reference,
+		Status:         transaction.Status,
+		Amount:         transaction.Amount,
+		RefundedAmount: transaction.RefundedAmount,
+		Message:        message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,3 @@
+module github.com/122140121-Hamka-RA/saga-order-system-PWL
+
+go 1.21
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ReservationStatusReserved  = "RESERVED"
+	ReservationStatusReleased  = "RELEASED"
+	ReservationStatusCommitted = "COMMITTED"
+)
+
+const StockFile = "stock.json"
+
+type StockLevel struct {
+	ItemID     string           `json:"item_id"`
+	OnHand     int              `json:"on_hand"`
+	Reserved   int              `json:"reserved"`
+	Available  int              `json:"available"`
+	Warehouses []WarehouseLevel `json:"warehouses"`
+}
+
+type WarehouseLevel struct {
+	WarehouseID string `json:"warehouse_id"`
+	OnHand      int    `json:"on_hand"`
+	Reserved    int    `json:"reserved"`
+	Available   int    `json:"available"`
+}
+
+type Reservation struct {
+	ID        string            `json:"id"`
+	OrderID   string            `json:"order_id"`
+	Reference string            `json:"reference,omitempty"`
+	Items     []ReservationItem `json:"items"`
+	Status    string            `json:"status"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type ReservationItem struct {
+	ID          string `json:"id"`
+	WarehouseID string `json:"warehouse_id,omitempty"`
+	Quantity    int    `json:"quantity"`
+}
+
+type ReserveStockRequest struct {
+	OrderID   string            `json:"order_id"`
+	Reference string            `json:"reference,omitempty"`
+	Items     []ReservationItem `json:"items"`
+}
+
+type StockRequest struct {
+	OrderID   string `json:"order_id"`
+	Reference string `json:"reference,omitempty"`
+}
+
+type RestockRequest struct {
+	OrderID   string            `json:"order_id"`
+	Reference string            `json:"reference"`
+	Items     []ReservationItem `json:"items"`
+}
+
+type StockResponse struct {
+	Success       bool   `json:"success"`
+	Message       string `json:"message"`
+	ReservationID string `json:"reservation_id,omitempty"`
+	OrderID       string `json:"order_id,omitempty"`
+	Status        string `json:"status,omitempty"`
+}
+
+type StockLevelResponse struct {
+	Success bool         `json:"success"`
+	Levels  []StockLevel `json:"levels"`
+}
+
+var (
+	stock        map[string]StockLevel
+	reservations = make(map[string]Reservation)
+	restocks     = make(map[string]bool)
+	mu           sync.Mutex
+	nextID       = 1
+)
+
+func main() {
+	levels, err := loadStock(StockFile)
+	if err != nil {
+		log.Fatalf("Failed to load stock from %s: %v", StockFile, err)
+	}
+	stock = levels
+
+	http.HandleFunc("/reserve-stock", reserveStockHandler)
+	http.HandleFunc("/release-stock", releaseStockHandler)
+	http.HandleFunc("/commit-stock", commitStockHandler)
+	http.HandleFunc("/restock", restockHandler)
+	http.HandleFunc("/stock-level", stockLevelHandler)
+
+	fmt.Println("Inventory Service started on :8084")
+	log.Fatal(http.ListenAndServe(":8084", nil))
+}
+
+func reserveStockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReserveStockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.OrderID == "" {
+		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "At least one item is required", http.StatusBadRequest)
+		return
+	}
+
+	requested := make(map[ReservationItem]int)
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			http.Error(w, fmt.Sprintf("Quantity for item %s must be greater than zero", item.ID), http.StatusBadRequest)
+			return
+		}
+		if item.WarehouseID == "" {
+			http.Error(w, fmt.Sprintf("Warehouse ID for item %s is required", item.ID), http.StatusBadRequest)
+			return
+		}
+		requested[ReservationItem{ID: item.ID, WarehouseID: item.WarehouseID}] += item.Quantity
+	}
+
+	key := reservationKey(req.OrderID, req.Reference)
+
+	mu.Lock()
+	if reservation, exists := reservations[key]; exists && reservation.Status != ReservationStatusReleased {
+		mu.Unlock()
+		writeStockResponse(w, http.StatusOK, StockResponse{
+			Success:       true,
+			Message:       "Stock already reserved",
+			ReservationID: reservation.ID,
+			OrderID:       req.OrderID,
+			Status:        reservation.Status,
+		})
+		return
+	}
+
+	for line, quantity := range requested {
+		warehouse, err := warehouseLevel(line.ID, line.WarehouseID)
+		if err != nil {
+			mu.Unlock()
+			writeStockResponse(w, http.StatusConflict, StockResponse{
+				Success: false,
+				Message: err.Error(),
+				OrderID: req.OrderID,
+			})
+			return
+		}
+		if warehouse.Available < quantity {
+			mu.Unlock()
+			writeStockResponse(w, http.StatusConflict, StockResponse{
+				Success: false,
+				Message: fmt.Sprintf("Insufficient stock for item %s in %s: available %d, requested %d", line.ID, line.WarehouseID, warehouse.Available, quantity),
+				OrderID: req.OrderID,
+			})
+			return
+		}
+	}
+
+	items := make([]ReservationItem, 0, len(requested))
+	for line, quantity := range requested {
+		line.Quantity = quantity
+		adjustStock(line, 0, quantity)
+		items = append(items, line)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].ID != items[j].ID {
+			return items[i].ID < items[j].ID
+		}
+		return items[i].WarehouseID < items[j].WarehouseID
+	})
+
+	reservationID := fmt.Sprintf("RSV-%d", nextID)
+	nextID++
+
+	now := time.Now()
+	reservations[key] = Reservation{
+		ID:        reservationID,
+		OrderID:   req.OrderID,
+		Reference: req.Reference,
+		Items:     items,
+		Status:    ReservationStatusReserved,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	mu.Unlock()
+
+	writeStockResponse(w, http.StatusOK, StockResponse{
+		Success:       true,
+		Message:       "Stock reserved successfully",
+		ReservationID: reservationID,
+		OrderID:       req.OrderID,
+		Status:        ReservationStatusReserved,
+	})
+
+	fmt.Printf("Stock reserved: %s for order %s\n", reservationID, req.OrderID)
+}
+
+func releaseStockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req StockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key := reservationKey(req.OrderID, req.Reference)
+
+	mu.Lock()
+	reservation, exists := reservations[key]
+	if !exists {
+		mu.Unlock()
+		http.Error(w, "Reservation not found", http.StatusNotFound)
+		return
+	}
+	if reservation.Status == ReservationStatusCommitted {
+		mu.Unlock()
+		http.Error(w, "Stock has already been committed", http.StatusConflict)
+		return
+	}
+
+	if reservation.Status == ReservationStatusReserved {
+		for _, item := range reservation.Items {
+			adjustStock(item, 0, -item.Quantity)
+		}
+		reservation.Status = ReservationStatusReleased
+		reservation.UpdatedAt = time.Now()
+		reservations[key] = reservation
+	}
+	mu.Unlock()
+
+	writeStockResponse(w, http.StatusOK, StockResponse{
+		Success:       true,
+		Message:       "Stock released successfully",
+		ReservationID: reservation.ID,
+		OrderID:       req.OrderID,
+		Status:        reservation.Status,
+	})
+
+	fmt.Printf("Stock released: %s for order %s\n", reservation.ID, req.OrderID)
+}
+
+func commitStockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req StockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	key := reservationKey(req.OrderID, req.Reference)
+
+	mu.Lock()
+	reservation, exists := reservations[key]
+	if !exists {
+		mu.Unlock()
+		http.Error(w, "Reservation not found", http.StatusNotFound)
+		return
+	}
+	if reservation.Status == ReservationStatusReleased {
+		mu.Unlock()
+		http.Error(w, "Stock has already been released", http.StatusConflict)
+		return
+	}
+
+	if reservation.Status == ReservationStatusReserved {
+		for _, item := range reservation.Items {
+			adjustStock(item, -item.Quantity, -item.Quantity)
+		}
+		reservation.Status = ReservationStatusCommitted
+		reservation.UpdatedAt = time.Now()
+		reservations[key] = reservation
+	}
+	mu.Unlock()
+
+	writeStockResponse(w, http.StatusOK, StockResponse{
+		Success:       true,
+		Message:       "Stock committed successfully",
+		ReservationID: reservation.ID,
+		OrderID:       req.OrderID,
+		Status:        reservation.Status,
+	})
+
+	fmt.Printf("Stock committed: %s for order %s\n", reservation.ID, req.OrderID)
+}
+
+func restockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RestockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Reference == "" {
+		http.Error(w, "Reference is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "At least one item is required", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	if restocks[req.Reference] {
+		mu.Unlock()
+		writeStockResponse(w, http.StatusOK, StockResponse{
+			Success: true,
+			Message: "Stock already returned",
+			OrderID: req.OrderID,
+		})
+		return
+	}
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Quantity for item %s must be greater than zero", item.ID), http.StatusBadRequest)
+			return
+		}
+		if item.WarehouseID == "" {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Warehouse ID for item %s is required", item.ID), http.StatusBadRequest)
+			return
+		}
+		if _, err := warehouseLevel(item.ID, item.WarehouseID); err != nil {
+			mu.Unlock()
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	for _, item := range req.Items {
+		adjustStock(item, item.Quantity, 0)
+	}
+	restocks[req.Reference] = true
+	mu.Unlock()
+
+	writeStockResponse(w, http.StatusOK, StockResponse{
+		Success: true,
+		Message: "Stock returned successfully",
+		OrderID: req.OrderID,
+	})
+
+	fmt.Printf("Stock returned for order %s: %d item line(s)\n", req.OrderID, len(req.Items))
+}
+
+func stockLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	itemID := r.URL.Query().Get("item_id")
+
+	mu.Lock()
+	levels := make([]StockLevel, 0, len(stock))
+	for id, level := range stock {
+		if itemID == "" || id == itemID {
+			level.Warehouses = append([]WarehouseLevel(nil), level.Warehouses...)
+			levels = append(levels, level)
+		}
+	}
+	mu.Unlock()
+
+	if itemID != "" && len(levels) == 0 {
+		http.Error(w, "Item not found", http.StatusNotFound)
+		return
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].ItemID < levels[j].ItemID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StockLevelResponse{
+		Success: true,
+		Levels:  levels,
+	})
+}
+
+func warehouseLevel(itemID, warehouseID string) (WarehouseLevel, error) {
+	level, known := stock[itemID]
+	if !known {
+		return WarehouseLevel{}, fmt.Errorf("Item %s is not stocked", itemID)
+	}
+	for _, warehouse := range level.Warehouses {
+		if warehouse.WarehouseID == warehouseID {
+			return warehouse, nil
+		}
+	}
+	return WarehouseLevel{}, fmt.Errorf("Item %s is not stocked in %s", itemID, warehouseID)
+}
+
+func adjustStock(item ReservationItem, onHand, reserved int) {
+	level := stock[item.ID]
+	for i := range level.Warehouses {
+		if level.Warehouses[i].WarehouseID == item.WarehouseID {
+			warehouse := &level.Warehouses[i]
+			warehouse.OnHand += onHand
+			warehouse.Reserved += reserved
+			warehouse.Available = warehouse.OnHand - warehouse.Reserved
+		}
+	}
+	level.OnHand += onHand
+	level.Reserved += reserved
+	level.Available = level.OnHand - level.Reserved
+	stock[item.ID] = level
+}
+
+func reservationKey(orderID, reference string) string {
+	if reference != "" {
+		return reference
+	}
+	return orderID
+}
+
+func writeStockResponse(w http.ResponseWriter, status int, resp StockResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func loadStock(path string) (map[string]StockLevel, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []StockLevel
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	levels := make(map[string]StockLevel, len(entries))
+	for _, entry := range entries {
+		if entry.ItemID == "" {
+			return nil, errors.New("stock entry without item ID")
+		}
+		if _, exists := levels[entry.ItemID]; exists {
+			return nil, fmt.Errorf("duplicate stock entry for item %s", entry.ItemID)
+		}
+		if len(entry.Warehouses) == 0 {
+			return nil, fmt.Errorf("item %s: at least one warehouse is required", entry.ItemID)
+		}
+		warehouses := make(map[string]bool)
+		entry.OnHand = 0
+		for i, warehouse := range entry.Warehouses {
+			if warehouse.WarehouseID == "" {
+				return nil, fmt.Errorf("item %s: warehouse without id", entry.ItemID)
+			}
+			if warehouses[warehouse.WarehouseID] {
+				return nil, fmt.Errorf("item %s: duplicate warehouse %s", entry.ItemID, warehouse.WarehouseID)
+			}
+			warehouses[warehouse.WarehouseID] = true
+			if warehouse.OnHand < 0 {
+				return nil, fmt.Errorf("item %s: on_hand in %s must not be negative", entry.ItemID, warehouse.WarehouseID)
+			}
+			entry.Warehouses[i].Reserved = 0
+			entry.Warehouses[i].Available = warehouse.OnHand
+			entry.OnHand += warehouse.OnHand
+		}
+		entry.Reserved = 0
+		entry.Available = entry.OnHand
+		levels[entry.ItemID] = entry
+	}
+	return levels, nil
+}
@@ -0,0 +1,118 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+type Money struct {
+	MinorUnits int64  `json:"minor_units"`
+	Currency   string `json:"currency"`
+}
+
+var exponents = map[string]int{
+	"EUR": 2,
+	"GBP": 2,
+	"IDR": 2,
+	"JPY": 0,
+	"SGD": 2,
+	"USD": 2,
+}
+
+var (
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrUnknownCurrency  = errors.New("unknown currency")
+)
+
+func Exponent(currency string) (int, bool) {
+	exponent, ok := exponents[currency]
+	return exponent, ok
+}
+
+func (m Money) Validate() error {
+	if _, ok := exponents[m.Currency]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCurrency, m.Currency)
+	}
+	return nil
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return Money{MinorUnits: m.MinorUnits + other.MinorUnits, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	return Money{MinorUnits: m.MinorUnits - other.MinorUnits, Currency: m.Currency}, nil
+}
+
+func (m Money) Cmp(other Money) (int, error) {
+	if m.Currency != other.Currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	switch {
+	case m.MinorUnits < other.MinorUnits:
+		return -1, nil
+	case m.MinorUnits > other.MinorUnits:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m Money) Mul(quantity int64) Money {
+	return Money{MinorUnits: m.MinorUnits * quantity, Currency: m.Currency}
+}
+
+func (m Money) MulRat(factor *big.Rat) Money {
+	product := new(big.Rat).Mul(new(big.Rat).SetInt64(m.MinorUnits), factor)
+	return Money{MinorUnits: RoundHalfEven(product), Currency: m.Currency}
+}
+
+func (m Money) IsPositive() bool {
+	return m.MinorUnits > 0
+}
+
+func (m Money) String() string {
+	exponent := exponents[m.Currency]
+	units := m.MinorUnits
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	if exponent == 0 {
+		return fmt.Sprintf("%s %s%d", m.Currency, sign, units)
+	}
+
+	scale := int64(1)
+	for i := 0; i < exponent; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s %s%d.%0*d", m.Currency, sign, units/scale, exponent, units%scale)
+}
+
+func RoundHalfEven(value *big.Rat) int64 {
+	quotient, remainder := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+
+	twice := new(big.Int).Abs(remainder)
+	twice.Lsh(twice, 1)
+	switch twice.Cmp(value.Denom()) {
+	case 1:
+	case 0:
+		if quotient.Bit(0) == 0 {
+			return quotient.Int64()
+		}
+	default:
+		return quotient.Int64()
+	}
+
+	if value.Sign() < 0 {
+		return quotient.Sub(quotient, big.NewInt(1)).Int64()
+	}
+	return quotient.Add(quotient, big.NewInt(1)).Int64()
+}
@@ -3,37 +3,145 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/address"
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/money"
+)
+
+const (
+	OrderServiceURL     = "http://localhost:8081"
+	PaymentServiceURL   = "http://localhost:8082"
+	ShippingServiceURL  = "http://localhost:8083"
+	InventoryServiceURL = "http://localhost:8084"
+)
+
+const (
+	TransactionStatusPending        = "PENDING"
+	TransactionStatusCompleted      = "COMPLETED"
+	TransactionStatusFailed         = "FAILED"
+	TransactionStatusManualReview   = "MANUAL_REVIEW"
+	TransactionStatusAwaitingReturn = "AWAITING_RETURN"
+	TransactionStatusCompensated    = "COMPENSATED"
+	TransactionStatusNeedsAttention = "NEEDS_ATTENTION"
+)
+
+const (
+	SagaTypeCreateOrder = "CREATE_ORDER"
+	SagaTypeAmendOrder  = "AMEND_ORDER"
+	SagaTypeReturnOrder = "RETURN_ORDER"
+	SagaTypeShipOrder   = "SHIP_ORDER"
+)
+
+const (
+	StepCompensatable = "COMPENSATABLE"
+	StepPivot         = "PIVOT"
+	StepRetriable     = "RETRIABLE"
+)
+
+const (
+	SagaPhaseCompensatable = "COMPENSATABLE"
+	SagaPhasePivot         = "PIVOT"
+	SagaPhaseRetriable     = "RETRIABLE"
+	SagaPhaseCompensating  = "COMPENSATING"
+	SagaPhaseDone          = "DONE"
+	SagaPhaseCompensated   = "COMPENSATED"
+)
+
+const (
+	FraudDecisionApprove = "APPROVE"
+	FraudDecisionReview  = "REVIEW"
+	FraudDecisionReject  = "REJECT"
+)
+
+const (
+	OrderStatusAwaitingPayment = "AWAITING_PAYMENT"
+	OrderStatusPaid            = "PAID"
+	OrderStatusShipping        = "SHIPPING"
+	OrderStatusCompleted       = "COMPLETED"
+	OrderStatusRefunded        = "REFUNDED"
+)
+
+const (
+	ShippingStatusDelivered          = "DELIVERED"
+	ShippingStatusPartiallyDelivered = "PARTIALLY_DELIVERED"
+	ShippingStatusCancelled          = "CANCELLED"
+)
+
+const ShippingTypeReturn = "RETURN"
+
+const (
+	ShippingPreferenceCheapest = "CHEAPEST"
+	ShippingPreferenceFastest  = "FASTEST"
+)
+
+const (
+	PaymentStatusPending           = "PENDING"
+	PaymentStatusSuccess           = "SUCCESS"
+	PaymentStatusRefunded          = "REFUNDED"
+	PaymentStatusPartiallyRefunded = "PARTIALLY_REFUNDED"
 )
 
 const (
-	OrderServiceURL    = "http://localhost:8081"
-	PaymentServiceURL  = "http://localhost:8082"
-	ShippingServiceURL = "http://localhost:8083"
+	MismatchChargedButFailed       = "CHARGED_BUT_FAILED"
+	MismatchRefundWithoutFailure   = "REFUND_WITHOUT_FAILED_SAGA"
+	MismatchCompletedWithoutCharge = "COMPLETED_WITHOUT_PAYMENT"
+	MismatchOrphanPayment          = "PAYMENT_WITHOUT_SAGA"
 )
 
 const (
-	TransactionStatusPending   = "PENDING"
-	TransactionStatusCompleted = "COMPLETED"
-	TransactionStatusFailed    = "FAILED"
+	PaymentConfirmationTimeout = 30 * time.Second
+	PollInterval               = 1 * time.Second
+	ReconciliationInterval     = 5 * time.Minute
+	ForwardRetryDelay          = 1 * time.Second
+	ForwardRetryMaxDelay       = 30 * time.Second
+)
+
+type Money = money.Money
+
+var (
+	ErrSagaSuspended = errors.New("saga suspended")
+	ErrPivotPassed   = errors.New("saga passed its pivot")
+
+	ErrRequestRejected = errors.New("request rejected")
+
+	ErrShipmentHandedOver = errors.New("shipment already handed to the carrier")
 )
 
 type Transaction struct {
-	ID            string    `json:"id"`
-	OrderID       string    `json:"order_id"`
-	CustomerID    string    `json:"customer_id"`
-	Amount        float64   `json:"amount"`
-	Address       string    `json:"address"`
-	Status        string    `json:"status"`
-	CreatedAt     time.Time `json:"created_at"`
-	CompletedAt   time.Time `json:"completed_at,omitempty"`
-	FailureReason string    `json:"failure_reason,omitempty"`
-	Steps         []Step    `json:"steps"`
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	OrderID       string     `json:"order_id"`
+	PaymentID     string     `json:"payment_id,omitempty"`
+	AmendmentID   string     `json:"amendment_id,omitempty"`
+	ReturnID      string     `json:"return_id,omitempty"`
+	CustomerID    string     `json:"customer_id"`
+	Amount        Money      `json:"amount"`
+	Address       Address    `json:"address"`
+	Shipments     []Shipment `json:"shipments,omitempty"`
+	Refunds       []Refund   `json:"refunds,omitempty"`
+	Status        string     `json:"status"`
+	Phase         string     `json:"phase,omitempty"`
+	ParentID      string     `json:"parent_id,omitempty"`
+	ChildIDs      []string   `json:"child_ids,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CompletedAt   time.Time  `json:"completed_at,omitempty"`
+	FailureReason string     `json:"failure_reason,omitempty"`
+	Steps         []Step     `json:"steps"`
+}
+
+type Refund struct {
+	PaymentID string `json:"payment_id"`
+	Amount    Money  `json:"amount"`
 }
 
 type Step struct {
@@ -44,24 +152,114 @@ type Step struct {
 	Error     string    `json:"error,omitempty"`
 }
 
+type Address = address.Address
+
+type AddressValidationResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message"`
+	Errors  []address.FieldError `json:"errors,omitempty"`
+}
+
 type CreateOrderRequest struct {
-	CustomerID string  `json:"customer_id"`
-	Items      []Item  `json:"items"`
-	Amount     float64 `json:"amount"`
-	Address    string  `json:"address"`
+	CustomerID         string   `json:"customer_id"`
+	Items              []Item   `json:"items"`
+	Amount             *Money   `json:"amount,omitempty"`
+	Currency           string   `json:"currency,omitempty"`
+	CouponCode         string   `json:"coupon_code,omitempty"`
+	Address            Address  `json:"address"`
+	BillingAddress     *Address `json:"billing_address,omitempty"`
+	PaymentMethod      string   `json:"payment_method,omitempty"`
+	ShippingPreference string   `json:"shipping_preference,omitempty"`
+}
+
+type AmendOrderRequest struct {
+	OrderID string   `json:"order_id"`
+	Items   []Item   `json:"items,omitempty"`
+	Address *Address `json:"address,omitempty"`
+}
+
+type ReturnOrderRequest struct {
+	OrderID string `json:"order_id"`
+	Items   []Item `json:"items"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type ReturnDecisionRequest struct {
+	TransactionID string `json:"transaction_id"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+type ReviewRequest struct {
+	TransactionID string `json:"transaction_id"`
+	Approve       bool   `json:"approve"`
+	Reason        string `json:"reason,omitempty"`
 }
 
 type Item struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Price       *Money `json:"price,omitempty"`
+	Quantity    int    `json:"quantity"`
+	WeightGrams int    `json:"weight_grams,omitempty"`
 }
 
 type OrderResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	OrderID     string `json:"order_id,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Amount      *Money `json:"amount,omitempty"`
+	WeightGrams int    `json:"weight_grams,omitempty"`
+	Items       []Item `json:"items,omitempty"`
+}
+
+type AmendOrderResponse struct {
+	Success         bool    `json:"success"`
+	Message         string  `json:"message"`
+	OrderID         string  `json:"order_id,omitempty"`
+	CustomerID      string  `json:"customer_id,omitempty"`
+	AmendmentID     string  `json:"amendment_id,omitempty"`
+	PreviousAmount  *Money  `json:"previous_amount,omitempty"`
+	Amount          *Money  `json:"amount,omitempty"`
+	WeightGrams     int     `json:"weight_grams,omitempty"`
+	PreviousItems   []Item  `json:"previous_items,omitempty"`
+	Items           []Item  `json:"items,omitempty"`
+	PreviousAddress Address `json:"previous_address"`
+	Address         Address `json:"address"`
+}
+
+type ReturnResponse struct {
+	Success     bool    `json:"success"`
+	Message     string  `json:"message"`
+	ReturnID    string  `json:"return_id,omitempty"`
+	OrderID     string  `json:"order_id,omitempty"`
+	CustomerID  string  `json:"customer_id,omitempty"`
+	Address     Address `json:"address"`
+	Status      string  `json:"status,omitempty"`
+	Amount      *Money  `json:"amount,omitempty"`
+	WeightGrams int     `json:"weight_grams,omitempty"`
+	Items       []Item  `json:"items,omitempty"`
+}
+
+type StockItem struct {
+	ID          string `json:"id"`
+	WarehouseID string `json:"warehouse_id,omitempty"`
+	Quantity    int    `json:"quantity"`
+}
+
+type StockResponse struct {
+	Success       bool   `json:"success"`
+	Message       string `json:"message"`
+	ReservationID string `json:"reservation_id,omitempty"`
+	OrderID       string `json:"order_id,omitempty"`
+	Status        string `json:"status,omitempty"`
+}
+
+type CouponResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	OrderID string `json:"order_id,omitempty"`
+	Code    string `json:"code,omitempty"`
 	Status  string `json:"status,omitempty"`
 }
 
@@ -73,12 +271,66 @@ type PaymentResponse struct {
 	Status    string `json:"status,omitempty"`
 }
 
+type FraudCheckResponse struct {
+	Success  bool     `json:"success"`
+	Message  string   `json:"message"`
+	CheckID  string   `json:"check_id,omitempty"`
+	OrderID  string   `json:"order_id,omitempty"`
+	Score    int      `json:"score"`
+	Decision string   `json:"decision,omitempty"`
+	Reasons  []string `json:"reasons,omitempty"`
+}
+
 type ShippingResponse struct {
-	Success    bool   `json:"success"`
-	Message    string `json:"message"`
-	ShippingID string `json:"shipping_id,omitempty"`
-	OrderID    string `json:"order_id,omitempty"`
-	Status     string `json:"status,omitempty"`
+	Success      bool       `json:"success"`
+	Message      string     `json:"message"`
+	ShippingID   string     `json:"shipping_id,omitempty"`
+	OrderID      string     `json:"order_id,omitempty"`
+	Address      Address    `json:"address"`
+	Carrier      string     `json:"carrier,omitempty"`
+	ServiceLevel string     `json:"service_level,omitempty"`
+	WeightGrams  int        `json:"weight_grams,omitempty"`
+	Cost         *Money     `json:"cost,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Shipments    []Shipment `json:"shipments,omitempty"`
+}
+
+type Shipment struct {
+	ShippingID   string         `json:"shipping_id,omitempty"`
+	WarehouseID  string         `json:"warehouse_id"`
+	Items        []ShipmentItem `json:"items"`
+	WeightGrams  int            `json:"weight_grams"`
+	Carrier      string         `json:"carrier,omitempty"`
+	ServiceLevel string         `json:"service_level,omitempty"`
+}
+
+type ShipmentItem struct {
+	ID          string `json:"id"`
+	Quantity    int    `json:"quantity"`
+	WeightGrams int    `json:"weight_grams,omitempty"`
+}
+
+type PlanShipmentsResponse struct {
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	OrderID   string     `json:"order_id,omitempty"`
+	Shipments []Shipment `json:"shipments,omitempty"`
+}
+
+type ShippingRate struct {
+	Carrier      string `json:"carrier"`
+	ServiceLevel string `json:"service_level"`
+	Price        Money  `json:"price"`
+	MinDays      int    `json:"min_days"`
+	MaxDays      int    `json:"max_days"`
+}
+
+type ShippingQuotesResponse struct {
+	Success     bool           `json:"success"`
+	WeightGrams int            `json:"weight_grams"`
+	Country     string         `json:"country"`
+	Preference  string         `json:"preference"`
+	Rates       []ShippingRate `json:"rates"`
 }
 
 type TransactionResponse struct {
@@ -87,345 +339,3097 @@ type TransactionResponse struct {
 	Transaction Transaction `json:"transaction,omitempty"`
 }
 
-var (
-	transactions = make(map[string]Transaction)
-	mu           sync.Mutex
-	nextID       = 1
-)
+type Payment struct {
+	ID             string `json:"id"`
+	OrderID        string `json:"order_id"`
+	CustomerID     string `json:"customer_id"`
+	Amount         Money  `json:"amount"`
+	RefundedAmount Money  `json:"refunded_amount"`
+	Method         string `json:"method"`
+	Status         string `json:"status"`
+}
+
+type PaymentListResponse struct {
+	Success  bool      `json:"success"`
+	Payments []Payment `json:"payments"`
+}
+
+type ReconciliationMismatch struct {
+	Type              string `json:"type"`
+	TransactionID     string `json:"transaction_id,omitempty"`
+	OrderID           string `json:"order_id,omitempty"`
+	PaymentID         string `json:"payment_id,omitempty"`
+	TransactionStatus string `json:"transaction_status,omitempty"`
+	PaymentStatus     string `json:"payment_status,omitempty"`
+	Detail            string `json:"detail"`
+}
+
+type ReconciliationReport struct {
+	GeneratedAt         time.Time                `json:"generated_at"`
+	TransactionsChecked int                      `json:"transactions_checked"`
+	PaymentsChecked     int                      `json:"payments_checked"`
+	Mismatches          []ReconciliationMismatch `json:"mismatches"`
+}
+
+type ReconciliationResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message"`
+	Report  ReconciliationReport `json:"report"`
+}
+
+type TransactionListResponse struct {
+	Success      bool          `json:"success"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+type sagaStep struct {
+	Name          string
+	Kind          string
+	DependsOn     []string
+	Failure       string
+	SuspendStatus string
+	SubSagaType   string
+	SubSaga       func(*sagaContext) *saga
+	Action        func(*sagaContext) error
+	Compensate    func(*sagaContext) error
+	Recover       func(*sagaContext) error
+}
+
+type saga struct {
+	Steps     []sagaStep
+	Completed []int
+	Suspended []int
+	Children  map[int]*saga
+	Phase     string
+	Context   *sagaContext
+	Done      chan struct{}
+}
+
+type stepResult struct {
+	Index       int
+	Err         error
+	PivotPassed bool
+	Child       *saga
+}
+
+type sagaContext struct {
+	TransactionID     string
+	OrderID           string
+	Order             CreateOrderRequest
+	FraudDecision     string
+	SuspendedStep     int
+	PaymentID         string
+	PaymentStatus     string
+	Paid              bool
+	Shipments         []Shipment
+	WeightGrams       int
+	Amendment         AmendOrderRequest
+	AmendmentID       string
+	OriginalPaymentID string
+	PreviousAmount    Money
+	PreviousAddress   Address
+	PreviousShipments []Shipment
+	HandedOver        []Shipment
+	Cancelled         []Shipment
+	Increases         []Item
+	Decreases         []Item
+	Return            ReturnOrderRequest
+	ReturnID          string
+	ReturnAmount      Money
+	ReturnShippingID  string
+	Refunded          Money
+}
+
+var (
+	transactions   = make(map[string]Transaction)
+	suspendedSagas = make(map[string]*saga)
+	mu             sync.Mutex
+	nextID         = 1
+)
+
+func main() {
+	http.HandleFunc("/create-order-saga", createOrderSagaHandler)
+	http.HandleFunc("/amend-order-saga", amendOrderSagaHandler)
+	http.HandleFunc("/return-order-saga", returnOrderSagaHandler)
+	http.HandleFunc("/receive-return", receiveReturnHandler)
+	http.HandleFunc("/cancel-return", cancelReturnHandler)
+	http.HandleFunc("/transaction-status", transactionStatusHandler)
+	http.HandleFunc("/manual-reviews", manualReviewsHandler)
+	http.HandleFunc("/review-transaction", reviewTransactionHandler)
+	http.HandleFunc("/reconciliation-report", reconciliationReportHandler)
+
+	go runReconciliationJob()
+
+	fmt.Println("Saga Orchestrator started on :8080")
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+func createOrderSagaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CustomerID == "" {
+		http.Error(w, "Customer ID is required", http.StatusBadRequest)
+		return
+	}
+	amount := Money{Currency: req.Currency}
+	if req.Amount != nil {
+		if req.Amount.Currency == "" {
+			req.Amount.Currency = req.Currency
+		}
+		amount = *req.Amount
+		req.Currency = amount.Currency
+	}
+	if err := amount.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid amount: %v", err), http.StatusBadRequest)
+		return
+	}
+	if amount.MinorUnits < 0 {
+		http.Error(w, "Amount must not be negative", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "Order must contain at least one item", http.StatusBadRequest)
+		return
+	}
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			http.Error(w, fmt.Sprintf("Quantity for item %s must be greater than zero", item.ID), http.StatusBadRequest)
+			return
+		}
+		if item.Price != nil && item.Price.Currency != amount.Currency {
+			http.Error(w, fmt.Sprintf("Invalid price for item %s: %v: %s and %s", item.ID, money.ErrCurrencyMismatch, item.Price.Currency, amount.Currency), http.StatusBadRequest)
+			return
+		}
+	}
+	normalized, err := address.Normalize(req.Address)
+	if err != nil {
+		writeAddressError(w, "Invalid shipping address", err)
+		return
+	}
+	req.Address = normalized
+	if req.BillingAddress != nil {
+		billing, err := address.Normalize(*req.BillingAddress)
+		if err != nil {
+			writeAddressError(w, "Invalid billing address", err)
+			return
+		}
+		req.BillingAddress = &billing
+	}
+	if req.ShippingPreference == "" {
+		req.ShippingPreference = ShippingPreferenceCheapest
+	}
+	if req.ShippingPreference != ShippingPreferenceCheapest && req.ShippingPreference != ShippingPreferenceFastest {
+		http.Error(w, "Shipping preference must be CHEAPEST or FASTEST", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	transactionID := fmt.Sprintf("TRX-%d", nextID)
+	nextID++
+
+	transaction := Transaction{
+		ID:         transactionID,
+		Type:       SagaTypeCreateOrder,
+		CustomerID: req.CustomerID,
+		Amount:     amount,
+		Address:    req.Address,
+		Status:     TransactionStatusPending,
+		CreatedAt:  time.Now(),
+		Steps:      []Step{},
+	}
+	transactions[transactionID] = transaction
+	mu.Unlock()
+
+	go runSaga(&saga{
+		Steps: createOrderSteps(),
+		Context: &sagaContext{
+			TransactionID: transactionID,
+			Order:         req,
+		},
+	})
+
+	resp := TransactionResponse{
+		Success:     true,
+		Message:     "Transaction initiated successfully",
+		Transaction: transaction,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Transaction initiated: %s\n", transactionID)
+}
+
+func amendOrderSagaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AmendOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.OrderID == "" {
+		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 && req.Address == nil {
+		http.Error(w, "Items or address must be provided", http.StatusBadRequest)
+		return
+	}
+	if req.Address != nil {
+		normalized, err := address.Normalize(*req.Address)
+		if err != nil {
+			writeAddressError(w, "Invalid shipping address", err)
+			return
+		}
+		req.Address = &normalized
+	}
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			http.Error(w, fmt.Sprintf("Quantity for item %s must be greater than zero", item.ID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mu.Lock()
+	var original Transaction
+	var found bool
+	for _, t := range transactions {
+		if t.OrderID != req.OrderID {
+			continue
+		}
+		if t.Type == SagaTypeAmendOrder && transactionInProgress(t) {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Order %s is already being amended by %s", req.OrderID, t.ID), http.StatusConflict)
+			return
+		}
+		if t.Type == SagaTypeReturnOrder && transactionInProgress(t) {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Order %s has a return in progress in %s", req.OrderID, t.ID), http.StatusConflict)
+			return
+		}
+		if t.Type == SagaTypeCreateOrder && t.Status == TransactionStatusCompleted {
+			original = t
+			found = true
+		}
+	}
+	if !found {
+		mu.Unlock()
+		http.Error(w, "No completed order saga found for the order", http.StatusConflict)
+		return
+	}
+
+	transactionID := fmt.Sprintf("TRX-%d", nextID)
+	nextID++
+
+	transaction := Transaction{
+		ID:         transactionID,
+		Type:       SagaTypeAmendOrder,
+		OrderID:    req.OrderID,
+		CustomerID: original.CustomerID,
+		Amount:     original.Amount,
+		Address:    original.Address,
+		Status:     TransactionStatusPending,
+		CreatedAt:  time.Now(),
+		Steps:      []Step{},
+	}
+	transactions[transactionID] = transaction
+	mu.Unlock()
+
+	go runSaga(&saga{
+		Steps: amendOrderSteps(),
+		Context: &sagaContext{
+			TransactionID:     transactionID,
+			OrderID:           req.OrderID,
+			Amendment:         req,
+			OriginalPaymentID: original.PaymentID,
+		},
+	})
+
+	resp := TransactionResponse{
+		Success:     true,
+		Message:     "Amendment initiated successfully",
+		Transaction: transaction,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Amendment initiated: %s for order %s\n", transactionID, req.OrderID)
+}
+
+func returnOrderSagaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReturnOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.OrderID == "" {
+		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "At least one item must be returned", http.StatusBadRequest)
+		return
+	}
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			http.Error(w, fmt.Sprintf("Quantity for item %s must be greater than zero", item.ID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	mu.Lock()
+	var original Transaction
+	var found bool
+	for _, t := range transactions {
+		if t.OrderID != req.OrderID {
+			continue
+		}
+		if t.Type == SagaTypeAmendOrder && transactionInProgress(t) {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Order %s is being amended by %s", req.OrderID, t.ID), http.StatusConflict)
+			return
+		}
+		if t.Type == SagaTypeCreateOrder && t.Status == TransactionStatusCompleted {
+			original = t
+			found = true
+		}
+	}
+	if !found {
+		mu.Unlock()
+		http.Error(w, "No completed order saga found for the order", http.StatusConflict)
+		return
+	}
+
+	transactionID := fmt.Sprintf("TRX-%d", nextID)
+	nextID++
+
+	transaction := Transaction{
+		ID:         transactionID,
+		Type:       SagaTypeReturnOrder,
+		OrderID:    req.OrderID,
+		CustomerID: original.CustomerID,
+		Amount:     Money{Currency: original.Amount.Currency},
+		Address:    original.Address,
+		Status:     TransactionStatusPending,
+		CreatedAt:  time.Now(),
+		Steps:      []Step{},
+	}
+	transactions[transactionID] = transaction
+	mu.Unlock()
+
+	go runSaga(&saga{
+		Steps: returnOrderSteps(),
+		Context: &sagaContext{
+			TransactionID: transactionID,
+			OrderID:       req.OrderID,
+			Return:        req,
+		},
+	})
+
+	resp := TransactionResponse{
+		Success:     true,
+		Message:     "Return initiated successfully",
+		Transaction: transaction,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Return initiated: %s for order %s\n", transactionID, req.OrderID)
+}
+
+func transactionInProgress(t Transaction) bool {
+	switch t.Status {
+	case TransactionStatusCompleted, TransactionStatusFailed, TransactionStatusCompensated, TransactionStatusNeedsAttention:
+		return false
+	}
+	return true
+}
+
+func receiveReturnHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReturnDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	suspended, err := takeSuspendedSaga(req.TransactionID, TransactionStatusAwaitingReturn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	updateStepStatus(req.TransactionID, suspended.Context.SuspendedStep, true, "")
+	updateTransactionStatus(req.TransactionID, TransactionStatusPending, "")
+	resumeSaga(suspended)
+
+	mu.Lock()
+	transaction := transactions[req.TransactionID]
+	mu.Unlock()
+
+	resp := TransactionResponse{
+		Success:     true,
+		Message:     "Return receipt recorded",
+		Transaction: transaction,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Return received for %s\n", req.TransactionID)
+}
+
+func cancelReturnHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReturnDecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	suspended, err := takeSuspendedSaga(req.TransactionID, TransactionStatusAwaitingReturn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+	updateStepStatus(req.TransactionID, suspended.Context.SuspendedStep, false, reason)
+	updateTransactionStatus(req.TransactionID, TransactionStatusPending, "")
+	go func() {
+		failSaga(suspended, fmt.Sprintf("Return cancelled: %s", reason))
+	}()
+
+	mu.Lock()
+	transaction := transactions[req.TransactionID]
+	mu.Unlock()
+
+	resp := TransactionResponse{
+		Success:     true,
+		Message:     "Return cancellation recorded",
+		Transaction: transaction,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Return cancelled for %s: %s\n", req.TransactionID, reason)
+}
+
+func takeSuspendedSaga(transactionID, status string) (*saga, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	transaction, exists := transactions[transactionID]
+	if !exists {
+		return nil, fmt.Errorf("Transaction %s not found", transactionID)
+	}
+	suspended, pending := suspendedSagas[transactionID]
+	if !pending || transaction.Status != status {
+		return nil, fmt.Errorf("Transaction %s is %s, not %s", transactionID, transaction.Status, status)
+	}
+	delete(suspendedSagas, transactionID)
+	return suspended, nil
+}
+
+func transactionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transactionID := r.URL.Query().Get("transaction_id")
+	if transactionID == "" {
+		http.Error(w, "Transaction ID is required", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	transaction, exists := transactions[transactionID]
+	mu.Unlock()
+	if !exists {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+
+	resp := TransactionResponse{
+		Success:     true,
+		Transaction: transaction,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func manualReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.Lock()
+	resp := TransactionListResponse{
+		Success:      true,
+		Transactions: []Transaction{},
+	}
+	for id := range suspendedSagas {
+		if transactions[id].Status == TransactionStatusManualReview {
+			resp.Transactions = append(resp.Transactions, transactions[id])
+		}
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func reviewTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	transaction, exists := transactions[req.TransactionID]
+	if !exists {
+		mu.Unlock()
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+	suspended, pending := suspendedSagas[req.TransactionID]
+	if !pending || transaction.Status != TransactionStatusManualReview {
+		mu.Unlock()
+		http.Error(w, "Transaction is not awaiting manual review", http.StatusConflict)
+		return
+	}
+	delete(suspendedSagas, req.TransactionID)
+	mu.Unlock()
+
+	if req.Approve {
+		updateStepStatus(req.TransactionID, suspended.Context.SuspendedStep, true, "")
+		updateTransactionStatus(req.TransactionID, TransactionStatusPending, "")
+		resumeSaga(suspended)
+	} else {
+		reason := req.Reason
+		if reason == "" {
+			reason = "no reason given"
+		}
+		updateStepStatus(req.TransactionID, suspended.Context.SuspendedStep, false, reason)
+		updateTransactionStatus(req.TransactionID, TransactionStatusPending, "")
+		go func() {
+			failSaga(suspended, fmt.Sprintf("Rejected during manual review: %s", reason))
+		}()
+	}
+
+	mu.Lock()
+	transaction = transactions[req.TransactionID]
+	mu.Unlock()
+
+	resp := TransactionResponse{
+		Success:     true,
+		Message:     "Manual review decision recorded",
+		Transaction: transaction,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Manual review for %s: approve=%v\n", req.TransactionID, req.Approve)
+}
+
+func reconciliationReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := reconcilePayments()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reconcile payments: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	resp := ReconciliationResponse{
+		Success: true,
+		Message: fmt.Sprintf("Found %d mismatch(es)", len(report.Mismatches)),
+		Report:  report,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func runReconciliationJob() {
+	ticker := time.NewTicker(ReconciliationInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report, err := reconcilePayments()
+		if err != nil {
+			fmt.Printf("Reconciliation failed: %v\n", err)
+			continue
+		}
+		for _, mismatch := range report.Mismatches {
+			fmt.Printf("Reconciliation mismatch %s: transaction %s, order %s, payment %s - %s\n",
+				mismatch.Type, mismatch.TransactionID, mismatch.OrderID, mismatch.PaymentID, mismatch.Detail)
+		}
+		fmt.Printf("Reconciliation completed: %d transaction(s), %d payment(s), %d mismatch(es)\n",
+			report.TransactionsChecked, report.PaymentsChecked, len(report.Mismatches))
+	}
+}
+
+func reconcilePayments() (ReconciliationReport, error) {
+	resp, err := http.Get(PaymentServiceURL + "/payments")
+	if err != nil {
+		return ReconciliationReport{}, err
+	}
+	defer resp.Body.Close()
+
+	var paymentList PaymentListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&paymentList); err != nil {
+		return ReconciliationReport{}, err
+	}
+
+	paymentsByOrder := make(map[string][]Payment)
+	for _, p := range paymentList.Payments {
+		paymentsByOrder[p.OrderID] = append(paymentsByOrder[p.OrderID], p)
+	}
+
+	mu.Lock()
+	snapshot := make([]Transaction, 0, len(transactions))
+	for _, t := range transactions {
+		snapshot = append(snapshot, t)
+	}
+	mu.Unlock()
+
+	report := ReconciliationReport{
+		GeneratedAt:         time.Now(),
+		TransactionsChecked: len(snapshot),
+		PaymentsChecked:     len(paymentList.Payments),
+		Mismatches:          []ReconciliationMismatch{},
+	}
+
+	expectedRefunds := make(map[string]Money)
+	for _, t := range snapshot {
+		if t.Status != TransactionStatusCompleted {
+			continue
+		}
+		for _, refund := range t.Refunds {
+			total, ok := expectedRefunds[refund.PaymentID]
+			if !ok {
+				total = Money{Currency: refund.Amount.Currency}
+			}
+			expectedRefunds[refund.PaymentID], _ = total.Add(refund.Amount)
+		}
+	}
+
+	knownOrders := make(map[string]bool)
+	for _, t := range snapshot {
+		if t.OrderID == "" {
+			continue
+		}
+		knownOrders[t.OrderID] = true
+
+		failed := t.Status == TransactionStatusFailed || t.Status == TransactionStatusNeedsAttention || t.Status == TransactionStatusCompensated
+		charged := false
+		for _, p := range paymentsByOrder[t.OrderID] {
+			if t.PaymentID != "" && p.ID != t.PaymentID {
+				continue
+			}
+			if t.PaymentID == "" && t.Type != SagaTypeCreateOrder {
+				continue
+			}
+
+			isCharged := p.Status == PaymentStatusSuccess || p.Status == PaymentStatusPartiallyRefunded
+			isRefunded := p.Status == PaymentStatusRefunded || p.Status == PaymentStatusPartiallyRefunded
+			if expected, ok := expectedRefunds[p.ID]; ok && isRefunded {
+				if cmp, err := p.RefundedAmount.Cmp(expected); err == nil && cmp <= 0 {
+					isCharged = true
+					isRefunded = false
+				}
+			}
+
+			switch {
+			case isCharged && failed:
+				report.Mismatches = append(report.Mismatches, ReconciliationMismatch{
+					Type:              MismatchChargedButFailed,
+					TransactionID:     t.ID,
+					OrderID:           t.OrderID,
+					PaymentID:         p.ID,
+					TransactionStatus: t.Status,
+					PaymentStatus:     p.Status,
+					Detail:            fmt.Sprintf("Customer was charged %s (refunded %s) but the saga ended %s", p.Amount, p.RefundedAmount, t.Status),
+				})
+			case isRefunded && !failed:
+				report.Mismatches = append(report.Mismatches, ReconciliationMismatch{
+					Type:              MismatchRefundWithoutFailure,
+					TransactionID:     t.ID,
+					OrderID:           t.OrderID,
+					PaymentID:         p.ID,
+					TransactionStatus: t.Status,
+					PaymentStatus:     p.Status,
+					Detail:            fmt.Sprintf("Payment of %s was refunded %s but the saga did not fail", p.Amount, p.RefundedAmount),
+				})
+			}
+			if isCharged {
+				charged = true
+			}
+		}
+
+		if t.Status == TransactionStatusCompleted && !charged && (t.Type == SagaTypeCreateOrder || t.PaymentID != "") {
+			report.Mismatches = append(report.Mismatches, ReconciliationMismatch{
+				Type:              MismatchCompletedWithoutCharge,
+				TransactionID:     t.ID,
+				OrderID:           t.OrderID,
+				PaymentID:         t.PaymentID,
+				TransactionStatus: t.Status,
+				Detail:            "Saga completed but no successful payment exists for the order",
+			})
+		}
+	}
+
+	for orderID, orderPayments := range paymentsByOrder {
+		if knownOrders[orderID] {
+			continue
+		}
+		for _, p := range orderPayments {
+			if p.Status != PaymentStatusSuccess {
+				continue
+			}
+			report.Mismatches = append(report.Mismatches, ReconciliationMismatch{
+				Type:          MismatchOrphanPayment,
+				OrderID:       orderID,
+				PaymentID:     p.ID,
+				PaymentStatus: p.Status,
+				Detail:        fmt.Sprintf("Payment of %s does not belong to any saga", p.Amount),
+			})
+		}
+	}
+
+	return report, nil
+}
+
+func runSaga(s *saga) {
+	transactionID := s.Context.TransactionID
+	if err := validateSteps(s.Steps, s.Done != nil); err != nil {
+		fmt.Printf("Transaction %s rejected: %v\n", transactionID, err)
+		finishSaga(s, TransactionStatusFailed, err.Error())
+		return
+	}
+
+	completed := make(map[string]bool)
+	for _, i := range s.Completed {
+		completed[s.Steps[i].Name] = true
+	}
+	started := make(map[string]bool)
+	results := make(chan stepResult)
+	running := 0
+	var failure, rejected *stepResult
+	s.Suspended = nil
+
+	for {
+		for i, step := range s.Steps {
+			if failure != nil {
+				break
+			}
+			if completed[step.Name] || started[step.Name] || !dependenciesMet(s.Steps, i, completed) {
+				continue
+			}
+			if step.Kind == StepPivot && !pivotReady(s.Steps, i, completed) {
+				continue
+			}
+			started[step.Name] = true
+			running++
+			enterStepPhase(s, step)
+			go runStep(s.Context, step, i, s.Phase == SagaPhaseRetriable, results)
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		step := s.Steps[result.Index]
+		if result.PivotPassed {
+			setSagaPhase(s, SagaPhaseRetriable)
+		}
+		switch {
+		case errors.Is(result.Err, ErrSagaSuspended):
+			s.Suspended = append(s.Suspended, result.Index)
+		case errors.Is(result.Err, ErrRequestRejected) && s.Phase == SagaPhaseRetriable:
+			if rejected == nil {
+				rejected = &result
+			}
+		case result.Err != nil && s.Phase == SagaPhaseRetriable:
+			running++
+			go func(step sagaStep, result stepResult) {
+				err := retryForward(s.Context, step, result.Err)
+				results <- stepResult{Index: result.Index, Err: err, Child: result.Child}
+			}(step, result)
+		case result.Err != nil:
+			if failure == nil {
+				failure = &result
+			}
+		default:
+			completed[step.Name] = true
+			s.Completed = append(s.Completed, result.Index)
+			if result.Child != nil {
+				if s.Children == nil {
+					s.Children = make(map[int]*saga)
+				}
+				s.Children[result.Index] = result.Child
+			}
+			if step.Kind == StepPivot {
+				setSagaPhase(s, SagaPhaseRetriable)
+			}
+		}
+	}
+
+	if failure != nil {
+		failSaga(s, fmt.Sprintf("%s: %v", s.Steps[failure.Index].Failure, failure.Err))
+		return
+	}
+	if rejected != nil {
+		finishSaga(s, TransactionStatusNeedsAttention, fmt.Sprintf("%s after the pivot: %v", s.Steps[rejected.Index].Failure, rejected.Err))
+		return
+	}
+	if len(s.Suspended) > 0 {
+		mu.Lock()
+		suspendedSagas[transactionID] = s
+		mu.Unlock()
+
+		updateTransactionStatus(transactionID, s.Steps[s.Suspended[0]].SuspendStatus, "")
+		return
+	}
+	if len(s.Completed) < len(s.Steps) {
+		failSaga(s, "Saga has steps with unmet dependencies")
+		return
+	}
+
+	finishSaga(s, TransactionStatusCompleted, "")
+}
+
+func finishSaga(s *saga, status, failureReason string) {
+	switch status {
+	case TransactionStatusCompleted:
+		setSagaPhase(s, SagaPhaseDone)
+	case TransactionStatusNeedsAttention:
+	default:
+		setSagaPhase(s, SagaPhaseCompensated)
+	}
+	updateTransactionStatus(s.Context.TransactionID, status, failureReason)
+	if s.Done != nil {
+		close(s.Done)
+	}
+}
+
+func resumeSaga(s *saga) {
+	s.Completed = append(s.Completed, s.Suspended...)
+	s.Suspended = nil
+	go runSaga(s)
+}
+
+func runStep(c *sagaContext, step sagaStep, index int, retriable bool, results chan<- stepResult) {
+	var child *saga
+	if step.SubSaga != nil {
+		action := step.Action
+		step.Action = func(c *sagaContext) error {
+			child = step.SubSaga(c)
+			if err := runChildSaga(c.TransactionID, step.SubSagaType, child); err != nil {
+				return err
+			}
+			if action != nil {
+				return action(c)
+			}
+			return nil
+		}
+	}
+
+	err := step.Action(c)
+	pivotPassed := errors.Is(err, ErrPivotPassed)
+	if err != nil && !errors.Is(err, ErrSagaSuspended) && (retriable || pivotPassed) {
+		err = retryForward(c, step, err)
+	}
+	results <- stepResult{Index: index, Err: err, PivotPassed: pivotPassed, Child: child}
+}
+
+func runChildSaga(parentID, sagaType string, child *saga) error {
+	mu.Lock()
+	parent := transactions[parentID]
+	childID := fmt.Sprintf("TRX-%d", nextID)
+	nextID++
+
+	transactions[childID] = Transaction{
+		ID:         childID,
+		Type:       sagaType,
+		OrderID:    parent.OrderID,
+		CustomerID: parent.CustomerID,
+		Amount:     parent.Amount,
+		Address:    parent.Address,
+		Status:     TransactionStatusPending,
+		ParentID:   parentID,
+		CreatedAt:  time.Now(),
+		Steps:      []Step{},
+	}
+	parent.ChildIDs = append(parent.ChildIDs, childID)
+	transactions[parentID] = parent
+	mu.Unlock()
+
+	fmt.Printf("Child transaction initiated: %s (%s) for %s\n", childID, sagaType, parentID)
+
+	child.Context.TransactionID = childID
+	child.Done = make(chan struct{})
+	go runSaga(child)
+	<-child.Done
+
+	mu.Lock()
+	transaction := transactions[childID]
+	mu.Unlock()
+
+	if transaction.Status != TransactionStatusCompleted {
+		return fmt.Errorf("child transaction %s %s: %s", childID, strings.ToLower(transaction.Status), transaction.FailureReason)
+	}
+	return nil
+}
+
+func dependenciesMet(steps []sagaStep, index int, completed map[string]bool) bool {
+	for _, name := range stepDependencies(steps, index) {
+		if !completed[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func validateSteps(steps []sagaStep, child bool) error {
+	for i, step := range steps {
+		if child && step.SuspendStatus != "" {
+			return fmt.Errorf("step %s of a sub-saga must not suspend it", step.Name)
+		}
+		if step.SubSaga == nil {
+			continue
+		}
+		if step.Kind != StepCompensatable {
+			return fmt.Errorf("sub-saga step %s must be compensatable, got %s", step.Name, step.Kind)
+		}
+		for _, pivot := range steps {
+			if pivot.Kind == StepPivot && dependsOnStep(steps, i, pivot.Name) {
+				return fmt.Errorf("sub-saga step %s must not run after pivot %s", step.Name, pivot.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func pivotReady(steps []sagaStep, pivot int, completed map[string]bool) bool {
+	for i, step := range steps {
+		if i == pivot || step.Kind != StepCompensatable || completed[step.Name] {
+			continue
+		}
+		if !dependsOnStep(steps, i, steps[pivot].Name) {
+			return false
+		}
+	}
+	return true
+}
+
+func dependsOnStep(steps []sagaStep, index int, name string) bool {
+	for _, dependency := range stepDependencies(steps, index) {
+		if dependency == name {
+			return true
+		}
+		for i, step := range steps {
+			if step.Name == dependency && dependsOnStep(steps, i, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stepDependencies(steps []sagaStep, index int) []string {
+	if steps[index].DependsOn == nil && index > 0 {
+		return []string{steps[index-1].Name}
+	}
+	return steps[index].DependsOn
+}
+
+func enterStepPhase(s *saga, step sagaStep) {
+	switch {
+	case step.Kind == StepRetriable:
+		setSagaPhase(s, SagaPhaseRetriable)
+	case step.Kind == StepPivot && s.Phase != SagaPhaseRetriable:
+		setSagaPhase(s, SagaPhasePivot)
+	case s.Phase == "":
+		setSagaPhase(s, SagaPhaseCompensatable)
+	}
+}
+
+func retryForward(c *sagaContext, step sagaStep, err error) error {
+	retry := step.Action
+	if step.Recover != nil && errors.Is(err, ErrPivotPassed) {
+		fmt.Printf("Transaction %s passed its pivot at %s, recovering forward: %v\n", c.TransactionID, step.Name, err)
+		retry = step.Recover
+		err = retry(c)
+	}
+	return retryWithBackoff(c, step.Name, err, retry)
+}
+
+func retryWithBackoff(c *sagaContext, name string, err error, retry func(*sagaContext) error) error {
+	delay := ForwardRetryDelay
+	for attempt := 1; err != nil; attempt++ {
+		if errors.Is(err, ErrRequestRejected) || errors.Is(err, ErrShipmentHandedOver) {
+			fmt.Printf("Not retrying %s for transaction %s: %v\n", name, c.TransactionID, err)
+			return err
+		}
+		fmt.Printf("Retrying %s for transaction %s in %s (attempt %d): %v\n", name, c.TransactionID, delay, attempt, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > ForwardRetryMaxDelay {
+			delay = ForwardRetryMaxDelay
+		}
+		err = retry(c)
+	}
+	return nil
+}
+
+func compensateSaga(s *saga) error {
+	setSagaPhase(s, SagaPhaseCompensating)
+	var failed []string
+	for i := len(s.Completed) - 1; i >= 0; i-- {
+		step := s.Steps[s.Completed[i]]
+		if step.Compensate != nil {
+			if err := retryWithBackoff(s.Context, "compensation of "+step.Name, step.Compensate(s.Context), step.Compensate); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", step.Name, err))
+			}
+		}
+		if child := s.Children[s.Completed[i]]; child != nil {
+			if err := compensateSaga(child); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", step.Name, err))
+				updateTransactionStatus(child.Context.TransactionID, TransactionStatusNeedsAttention, fmt.Sprintf("Compensation requested by parent transaction %s failed: %v", s.Context.TransactionID, err))
+				continue
+			}
+			setSagaPhase(child, SagaPhaseCompensated)
+			updateTransactionStatus(child.Context.TransactionID, TransactionStatusCompensated, fmt.Sprintf("Compensated by parent transaction %s", s.Context.TransactionID))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("compensation failed for %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func failSaga(s *saga, failureReason string) {
+	if err := compensateSaga(s); err != nil {
+		finishSaga(s, TransactionStatusNeedsAttention, fmt.Sprintf("%s; %v", failureReason, err))
+		return
+	}
+	finishSaga(s, TransactionStatusFailed, failureReason)
+}
+
+func setSagaPhase(s *saga, phase string) {
+	s.Phase = phase
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	transaction, exists := transactions[s.Context.TransactionID]
+	if !exists || transaction.Phase == phase {
+		return
+	}
+	transaction.Phase = phase
+	transactions[s.Context.TransactionID] = transaction
+
+	fmt.Printf("Transaction phase updated: %s - %s\n", s.Context.TransactionID, phase)
+}
+
+func createOrderSteps() []sagaStep {
+	return []sagaStep{
+		{
+			Name:    "CREATE_ORDER",
+			Kind:    StepCompensatable,
+			Failure: "Failed to create order",
+			Action: func(c *sagaContext) error {
+				orderResp, err := createOrder(c.TransactionID, c.Order)
+				if err != nil {
+					return err
+				}
+				c.OrderID = orderResp.OrderID
+				c.Order.Amount = orderResp.Amount
+				c.Order.Items = orderResp.Items
+				c.WeightGrams = orderResp.WeightGrams
+
+				mu.Lock()
+				transaction := transactions[c.TransactionID]
+				transaction.OrderID = c.OrderID
+				transaction.Amount = *c.Order.Amount
+				transactions[c.TransactionID] = transaction
+				mu.Unlock()
+				return nil
+			},
+			Compensate: func(c *sagaContext) error {
+				if c.Paid {
+					return refundOrder(c.TransactionID, c.OrderID)
+				}
+				return cancelOrder(c.TransactionID, c.OrderID)
+			},
+		},
+		{
+			Name:    "PLAN_SHIPMENTS",
+			Kind:    StepCompensatable,
+			Failure: "Failed to plan shipments",
+			Action: func(c *sagaContext) error {
+				shipments, err := planShipments(c.TransactionID, c.OrderID, c.Order.Address, c.Order.Items)
+				if err != nil {
+					return err
+				}
+				c.Shipments = shipments
+				recordShipments(c.TransactionID, c.Shipments)
+				return nil
+			},
+		},
+		{
+			Name:    "RESERVE_STOCK",
+			Kind:    StepCompensatable,
+			Failure: "Failed to reserve stock",
+			Action: func(c *sagaContext) error {
+				return reserveStock(c.TransactionID, c.OrderID, "", shipmentStock(c.Shipments))
+			},
+			Compensate: func(c *sagaContext) error {
+				return releaseStock(c.TransactionID, c.OrderID, "")
+			},
+		},
+		{
+			Name:      "RESERVE_COUPON",
+			Kind:      StepCompensatable,
+			DependsOn: []string{"CREATE_ORDER"},
+			Failure:   "Failed to reserve coupon",
+			Action: func(c *sagaContext) error {
+				if c.Order.CouponCode == "" {
+					return nil
+				}
+				return reserveCoupon(c.TransactionID, c.OrderID)
+			},
+			Compensate: func(c *sagaContext) error {
+				return releaseCoupon(c.TransactionID, c.OrderID, c.Order.CouponCode)
+			},
+		},
+		{
+			Name:      "FRAUD_CHECK",
+			Kind:      StepCompensatable,
+			DependsOn: []string{"CREATE_ORDER"},
+			Failure:   "Fraud check failed",
+			Action: func(c *sagaContext) error {
+				fraudResp, err := checkFraud(c.TransactionID, c.OrderID, c.Order)
+				c.FraudDecision = fraudResp.Decision
+				return err
+			},
+		},
+		{
+			Name:          "MANUAL_REVIEW",
+			Kind:          StepCompensatable,
+			Failure:       "Manual review failed",
+			SuspendStatus: TransactionStatusManualReview,
+			Action: func(c *sagaContext) error {
+				if c.FraudDecision != FraudDecisionReview {
+					return nil
+				}
+				c.SuspendedStep = addStep(c.TransactionID, "MANUAL_REVIEW")
+				return ErrSagaSuspended
+			},
+		},
+		{
+			Name:      "MARK_AWAITING_PAYMENT",
+			Kind:      StepCompensatable,
+			DependsOn: []string{"RESERVE_STOCK", "RESERVE_COUPON", "MANUAL_REVIEW"},
+			Failure:   "Failed to update order",
+			Action: func(c *sagaContext) error {
+				return updateOrderStatus(c.OrderID, OrderStatusAwaitingPayment, "")
+			},
+		},
+		{
+			Name:    "PROCESS_PAYMENT",
+			Kind:    StepCompensatable,
+			Failure: "Failed to process payment",
+			Action: func(c *sagaContext) error {
+				paymentResp, err := processPayment(c.TransactionID, c.OrderID, c.Order)
+				c.PaymentID = paymentResp.PaymentID
+				c.PaymentStatus = paymentResp.Status
+				return err
+			},
+			Compensate: func(c *sagaContext) error {
+				return refundPayment(c.TransactionID, c.OrderID, c.PaymentID)
+			},
+		},
+		{
+			Name:    "AWAIT_PAYMENT_CONFIRMATION",
+			Kind:    StepCompensatable,
+			Failure: "Payment was not confirmed",
+			Action: func(c *sagaContext) error {
+				if c.PaymentStatus != PaymentStatusPending {
+					return nil
+				}
+				return awaitPaymentConfirmation(c.TransactionID, c.PaymentID)
+			},
+		},
+		{
+			Name:    "MARK_PAID",
+			Kind:    StepCompensatable,
+			Failure: "Failed to update order",
+			Action: func(c *sagaContext) error {
+				if err := updateOrderStatus(c.OrderID, OrderStatusPaid, ""); err != nil {
+					return err
+				}
+				c.Paid = true
+				return nil
+			},
+		},
+		{
+			Name:      "SELECT_CARRIER",
+			Kind:      StepCompensatable,
+			DependsOn: []string{"PLAN_SHIPMENTS"},
+			Failure:   "Failed to select a carrier",
+			Action: func(c *sagaContext) error {
+				for i := range c.Shipments {
+					rate, err := selectCarrier(c.TransactionID, c.Order.Address, c.Shipments[i].WeightGrams, c.Order.ShippingPreference)
+					if err != nil {
+						return err
+					}
+					c.Shipments[i].Carrier = rate.Carrier
+					c.Shipments[i].ServiceLevel = rate.ServiceLevel
+				}
+				recordShipments(c.TransactionID, c.Shipments)
+				return nil
+			},
+		},
+		{
+			Name:        "SHIP_ORDER",
+			Kind:        StepCompensatable,
+			DependsOn:   []string{"MARK_PAID", "SELECT_CARRIER"},
+			Failure:     "Failed to start shipping",
+			SubSagaType: SagaTypeShipOrder,
+			SubSaga: func(c *sagaContext) *saga {
+				return &saga{
+					Steps: shipOrderSteps(c.Shipments),
+					Context: &sagaContext{
+						OrderID:   c.OrderID,
+						Order:     c.Order,
+						Shipments: c.Shipments,
+					},
+				}
+			},
+			Action: func(c *sagaContext) error {
+				recordShipments(c.TransactionID, c.Shipments)
+				return nil
+			},
+		},
+		{
+			Name:    "MARK_SHIPPING",
+			Kind:    StepCompensatable,
+			Failure: "Failed to update order",
+			Action: func(c *sagaContext) error {
+				return updateOrderStatus(c.OrderID, OrderStatusShipping, "")
+			},
+		},
+		{
+			Name:      "COMMIT_STOCK",
+			Kind:      StepPivot,
+			DependsOn: []string{"MARK_PAID"},
+			Failure:   "Failed to commit stock",
+			Action: func(c *sagaContext) error {
+				return commitStock(c.TransactionID, c.OrderID, "")
+			},
+		},
+		{
+			Name:      "MARK_COMPLETED",
+			Kind:      StepRetriable,
+			DependsOn: []string{"MARK_SHIPPING", "COMMIT_STOCK"},
+			Failure:   "Failed to update order",
+			Action: func(c *sagaContext) error {
+				return updateOrderStatus(c.OrderID, OrderStatusCompleted, "")
+			},
+		},
+	}
+}
+
+func shipOrderSteps(shipments []Shipment) []sagaStep {
+	steps := []sagaStep{}
+	for i, shipment := range shipments {
+		i := i
+		steps = append(steps, sagaStep{
+			Name:      "SHIP_FROM_" + shipment.WarehouseID,
+			Kind:      StepCompensatable,
+			DependsOn: []string{},
+			Failure:   fmt.Sprintf("Failed to start shipment from %s", shipment.WarehouseID),
+			Action: func(c *sagaContext) error {
+				shippingID, err := startShipping(c.TransactionID, c.OrderID, c.Order.Address, c.Shipments[i])
+				c.Shipments[i].ShippingID = shippingID
+				return err
+			},
+			Compensate: func(c *sagaContext) error {
+				return cancelShipping(c.TransactionID, c.OrderID, c.Shipments[i].ShippingID)
+			},
+		})
+	}
+	return steps
+}
+
+func amendOrderSteps() []sagaStep {
+	return []sagaStep{
+		{
+			Name:    "AMEND_ORDER",
+			Kind:    StepCompensatable,
+			Failure: "Failed to amend order",
+			Action:  amendOrder,
+			Compensate: func(c *sagaContext) error {
+				return revertAmendment(c.TransactionID, c.OrderID, c.AmendmentID)
+			},
+		},
+		{
+			Name:    "RESERVE_STOCK",
+			Kind:    StepCompensatable,
+			Failure: "Failed to reserve stock",
+			Action: func(c *sagaContext) error {
+				if len(c.Increases) == 0 && len(c.Decreases) == 0 {
+					return nil
+				}
+				shipments, err := planShipments(c.TransactionID, c.OrderID, c.Order.Address, c.Order.Items)
+				if err != nil {
+					return err
+				}
+				c.Shipments = shipments
+				return reserveStock(c.TransactionID, c.OrderID, c.AmendmentID, shipmentStock(c.Shipments))
+			},
+			Compensate: func(c *sagaContext) error {
+				if len(c.Increases) == 0 && len(c.Decreases) == 0 {
+					return nil
+				}
+				return releaseStock(c.TransactionID, c.OrderID, c.AmendmentID)
+			},
+		},
+		{
+			Name:      "PROCESS_PAYMENT",
+			Kind:      StepCompensatable,
+			DependsOn: []string{"AMEND_ORDER"},
+			Failure:   "Failed to charge the difference",
+			Action: func(c *sagaContext) error {
+				difference, err := c.Order.Amount.Sub(c.PreviousAmount)
+				if err != nil {
+					return err
+				}
+				if !difference.IsPositive() {
+					return nil
+				}
+				original, err := fetchPayment(c.OrderID, c.OriginalPaymentID)
+				if err != nil {
+					return err
+				}
+				paymentResp, err := processPayment(c.TransactionID, c.OrderID, CreateOrderRequest{
+					CustomerID:    c.Order.CustomerID,
+					Amount:        &difference,
+					PaymentMethod: original.Method,
+				})
+				c.PaymentID = paymentResp.PaymentID
+				c.PaymentStatus = paymentResp.Status
+				return err
+			},
+			Compensate: func(c *sagaContext) error {
+				if c.PaymentID == "" {
+					return nil
+				}
+				return refundPayment(c.TransactionID, c.OrderID, c.PaymentID)
+			},
+		},
+		{
+			Name:    "AWAIT_PAYMENT_CONFIRMATION",
+			Kind:    StepCompensatable,
+			Failure: "Payment was not confirmed",
+			Action: func(c *sagaContext) error {
+				if c.PaymentStatus != PaymentStatusPending {
+					return nil
+				}
+				return awaitPaymentConfirmation(c.TransactionID, c.PaymentID)
+			},
+		},
+		{
+			Name:      "UPDATE_SHIPPING",
+			Kind:      StepCompensatable,
+			DependsOn: []string{"RESERVE_STOCK", "AWAIT_PAYMENT_CONFIRMATION"},
+			Failure:   "Failed to update shipping",
+			Action: func(c *sagaContext) error {
+				if len(c.Increases) > 0 || len(c.Decreases) > 0 || c.Order.Address == c.PreviousAddress {
+					return nil
+				}
+				return updateShipping(c.TransactionID, c.OrderID, c.Order.Address)
+			},
+			Compensate: func(c *sagaContext) error {
+				if len(c.Increases) > 0 || len(c.Decreases) > 0 || c.Order.Address == c.PreviousAddress {
+					return nil
+				}
+				return updateShipping(c.TransactionID, c.OrderID, c.PreviousAddress)
+			},
+		},
+		{
+			Name:    "START_SHIPPING",
+			Kind:    StepCompensatable,
+			Failure: "Failed to start shipping",
+			Action: func(c *sagaContext) error {
+				if len(c.Increases) == 0 && len(c.Decreases) == 0 {
+					return nil
+				}
+				previous, err := shippingStatus(c.OrderID)
+				if err != nil {
+					return err
+				}
+				if previous.Status == ShippingStatusDelivered || previous.Status == ShippingStatusPartiallyDelivered {
+					return fmt.Errorf("order is already %s, request a return instead", previous.Status)
+				}
+				if previous.Status != ShippingStatusCancelled {
+					c.PreviousShipments = previous.Shipments
+				}
+				for i := range c.Shipments {
+					c.Shipments[i].Carrier = previous.Carrier
+					c.Shipments[i].ServiceLevel = previous.ServiceLevel
+				}
+				err = startShipments(c.TransactionID, c.OrderID, c.Order.Address, c.Shipments)
+				recordShipments(c.TransactionID, c.Shipments)
+				return err
+			},
+			Compensate: func(c *sagaContext) error {
+				return cancelShipments(c.TransactionID, c.OrderID, c.Shipments)
+			},
+		},
+		{
+			Name:    "CANCEL_SHIPPING",
+			Kind:    StepPivot,
+			Failure: "Failed to cancel the previous shipment",
+			Action: func(c *sagaContext) error {
+				var cancelled []int
+				c.HandedOver = nil
+				c.Cancelled = nil
+				for i, shipment := range c.PreviousShipments {
+					err := cancelShipping(c.TransactionID, c.OrderID, shipment.ShippingID)
+					if errors.Is(err, ErrShipmentHandedOver) {
+						c.HandedOver = append(c.HandedOver, shipment)
+						continue
+					}
+					if err != nil {
+						if restoreErr := restoreShipments(c, cancelled); restoreErr != nil {
+							return fmt.Errorf("%w (%v)", err, restoreErr)
+						}
+						return err
+					}
+					cancelled = append(cancelled, i)
+					c.Cancelled = append(c.Cancelled, shipment)
+				}
+				if len(c.HandedOver) > 0 {
+					return fmt.Errorf("%w: %d previous shipment(s) already picked up by the carrier", ErrPivotPassed, len(c.HandedOver))
+				}
+				return nil
+			},
+			Recover: func(c *sagaContext) error {
+				for len(c.HandedOver) > 0 {
+					if err := returnToSender(c.TransactionID, c.OrderID, c.HandedOver[0].ShippingID); err != nil {
+						return err
+					}
+					c.HandedOver = c.HandedOver[1:]
+				}
+				return nil
+			},
+		},
+		{
+			Name:    "COMMIT_STOCK",
+			Kind:    StepRetriable,
+			Failure: "Failed to commit stock",
+			Action: func(c *sagaContext) error {
+				if len(c.Increases) == 0 && len(c.Decreases) == 0 {
+					return nil
+				}
+				return commitStock(c.TransactionID, c.OrderID, c.AmendmentID)
+			},
+		},
+		{
+			Name:      "REFUND_DIFFERENCE",
+			Kind:      StepRetriable,
+			DependsOn: []string{"CANCEL_SHIPPING"},
+			Failure:   "Failed to refund the difference",
+			Action: func(c *sagaContext) error {
+				difference, err := c.PreviousAmount.Sub(*c.Order.Amount)
+				if err != nil {
+					return err
+				}
+				if !difference.IsPositive() {
+					return nil
+				}
+				return refundOrderPayments(c, "REFUND_DIFFERENCE", difference)
+			},
+		},
+		{
+			Name:      "RESTOCK_ITEMS",
+			Kind:      StepRetriable,
+			DependsOn: []string{"CANCEL_SHIPPING"},
+			Failure:   "Failed to restock items",
+			Action: func(c *sagaContext) error {
+				if len(c.Cancelled) == 0 {
+					return nil
+				}
+				return restockItems(c.TransactionID, c.OrderID, shipmentStock(c.Cancelled))
+			},
+		},
+	}
+}
+
+func returnOrderSteps() []sagaStep {
+	return []sagaStep{
+		{
+			Name:    "OPEN_RETURN",
+			Kind:    StepCompensatable,
+			Failure: "Failed to open return",
+			Action:  openReturn,
+			Compensate: func(c *sagaContext) error {
+				return cancelReturn(c.TransactionID, c.OrderID, c.ReturnID)
+			},
+		},
+		{
+			Name:    "START_RETURN_SHIPPING",
+			Kind:    StepCompensatable,
+			Failure: "Failed to start return shipping",
+			Action: func(c *sagaContext) error {
+				shippingID, err := startReturnShipping(c.TransactionID, c.OrderID, c.ReturnID, c.Order.Address, c.WeightGrams)
+				c.ReturnShippingID = shippingID
+				return err
+			},
+			Compensate: func(c *sagaContext) error {
+				if c.ReturnShippingID == "" {
+					return nil
+				}
+				return cancelShipping(c.TransactionID, c.OrderID, c.ReturnShippingID)
+			},
+		},
+		{
+			Name:          "AWAIT_RETURN",
+			Kind:          StepCompensatable,
+			Failure:       "Return was not received",
+			SuspendStatus: TransactionStatusAwaitingReturn,
+			Action: func(c *sagaContext) error {
+				c.SuspendedStep = addStep(c.TransactionID, "AWAIT_RETURN")
+				return ErrSagaSuspended
+			},
+		},
+		{
+			Name:    "RECEIVE_RETURN",
+			Kind:    StepPivot,
+			Failure: "Failed to receive return",
+			Action: func(c *sagaContext) error {
+				return receiveReturnShipment(c.TransactionID, c.ReturnShippingID)
+			},
+		},
+		{
+			Name:    "REFUND_RETURN",
+			Kind:    StepRetriable,
+			Failure: "Failed to refund return",
+			Action: func(c *sagaContext) error {
+				return refundOrderPayments(c, "REFUND_RETURN", c.ReturnAmount)
+			},
+		},
+		{
+			Name:    "COMPLETE_RETURN",
+			Kind:    StepRetriable,
+			Failure: "Failed to complete return",
+			Action: func(c *sagaContext) error {
+				return completeReturn(c.TransactionID, c.OrderID, c.ReturnID)
+			},
+		},
+		{
+			Name:      "RESTOCK_ITEMS",
+			Kind:      StepRetriable,
+			DependsOn: []string{"REFUND_RETURN"},
+			Failure:   "Failed to restock items",
+			Action: func(c *sagaContext) error {
+				shipping, err := shippingStatus(c.OrderID)
+				if err != nil {
+					return err
+				}
+				items, err := returnedStock(c.Return.Items, shipping.Shipments)
+				if err != nil {
+					return err
+				}
+				return restockItems(c.TransactionID, c.OrderID, items)
+			},
+		},
+	}
+}
+
+func createOrder(transactionID string, req CreateOrderRequest) (OrderResponse, error) {
+	step := addStep(transactionID, "CREATE_ORDER")
+
+	orderReq := map[string]interface{}{
+		"customer_id": req.CustomerID,
+		"items":       req.Items,
+		"amount":      req.Amount,
+		"currency":    req.Currency,
+		"coupon_code": req.CouponCode,
+		"address":     req.Address,
+	}
+	reqBody, err := json.Marshal(orderReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return OrderResponse{}, err
+	}
+
+	resp, err := http.Post(OrderServiceURL+"/create-order", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return OrderResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return OrderResponse{}, err
+	}
+
+	var orderResp OrderResponse
+	if err := json.Unmarshal(body, &orderResp); err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return OrderResponse{}, err
+	}
+
+	if !orderResp.Success {
+		updateStepStatus(transactionID, step, false, orderResp.Message)
+		return OrderResponse{}, errors.New(orderResp.Message)
+	}
+	if orderResp.Amount == nil {
+		updateStepStatus(transactionID, step, false, "order service did not return a total")
+		return OrderResponse{}, errors.New("order service did not return a total")
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Order created: %s with total %s\n", orderResp.OrderID, orderResp.Amount)
+	return orderResp, nil
+}
+
+func reserveStock(transactionID, orderID, reference string, items []StockItem) error {
+	step := addStep(transactionID, "RESERVE_STOCK")
+
+	reserveReq := map[string]interface{}{
+		"order_id":  orderID,
+		"reference": reference,
+		"items":     items,
+	}
+	reqBody, err := json.Marshal(reserveReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(InventoryServiceURL+"/reserve-stock", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	var stockResp StockResponse
+	if err := json.Unmarshal(body, &stockResp); err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	if !stockResp.Success {
+		updateStepStatus(transactionID, step, false, stockResp.Message)
+		return errors.New(stockResp.Message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Stock reserved for order: %s (%s)\n", orderID, stockResp.ReservationID)
+	return nil
+}
+
+func commitStock(transactionID, orderID, reference string) error {
+	step := addStep(transactionID, "COMMIT_STOCK")
+
+	commitReq := map[string]interface{}{
+		"order_id":  orderID,
+		"reference": reference,
+	}
+	reqBody, err := json.Marshal(commitReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(InventoryServiceURL+"/commit-stock", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(string(body))
+		updateStepStatus(transactionID, step, false, message)
+		return errors.New(message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Stock committed for order: %s\n", orderID)
+	return nil
+}
+
+func reserveCoupon(transactionID, orderID string) error {
+	step := addStep(transactionID, "RESERVE_COUPON")
+
+	couponReq := map[string]interface{}{
+		"order_id": orderID,
+	}
+	reqBody, err := json.Marshal(couponReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(OrderServiceURL+"/reserve-coupon", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	var couponResp CouponResponse
+	if err := json.Unmarshal(body, &couponResp); err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	if !couponResp.Success {
+		updateStepStatus(transactionID, step, false, couponResp.Message)
+		return errors.New(couponResp.Message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Coupon %s reserved for order: %s\n", couponResp.Code, orderID)
+	return nil
+}
+
+func checkFraud(transactionID, orderID string, req CreateOrderRequest) (FraudCheckResponse, error) {
+	step := addStep(transactionID, "FRAUD_CHECK")
+
+	billingAddress := ""
+	if req.BillingAddress != nil {
+		billingAddress = req.BillingAddress.String()
+	}
+	fraudReq := map[string]interface{}{
+		"order_id":         orderID,
+		"customer_id":      req.CustomerID,
+		"amount":           req.Amount,
+		"shipping_address": req.Address.String(),
+		"billing_address":  billingAddress,
+	}
+	reqBody, err := json.Marshal(fraudReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return FraudCheckResponse{}, err
+	}
+
+	resp, err := http.Post(PaymentServiceURL+"/fraud-check", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return FraudCheckResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return FraudCheckResponse{}, err
+	}
+
+	var fraudResp FraudCheckResponse
+	if err := json.Unmarshal(body, &fraudResp); err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return FraudCheckResponse{}, err
+	}
+
+	if !fraudResp.Success {
+		updateStepStatus(transactionID, step, false, fraudResp.Message)
+		return fraudResp, errors.New(fraudResp.Message)
+	}
+
+	if fraudResp.Decision == FraudDecisionReject {
+		err := fmt.Errorf("order rejected with score %d: %s", fraudResp.Score, strings.Join(fraudResp.Reasons, "; "))
+		updateStepStatus(transactionID, step, false, err.Error())
+		return fraudResp, err
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Fraud check for order %s: %s (score %d)\n", orderID, fraudResp.Decision, fraudResp.Score)
+	return fraudResp, nil
+}
+
+func processPayment(transactionID, orderID string, req CreateOrderRequest) (PaymentResponse, error) {
+	step := addStep(transactionID, "PROCESS_PAYMENT")
+
+	paymentReq := map[string]interface{}{
+		"order_id":    orderID,
+		"customer_id": req.CustomerID,
+		"amount":      req.Amount,
+		"method":      req.PaymentMethod,
+	}
+	reqBody, err := json.Marshal(paymentReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return PaymentResponse{}, err
+	}
+
+	resp, err := http.Post(PaymentServiceURL+"/process-payment", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return PaymentResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return PaymentResponse{}, err
+	}
+
+	var paymentResp PaymentResponse
+	if err := json.Unmarshal(body, &paymentResp); err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return PaymentResponse{}, err
+	}
+
+	if paymentResp.PaymentID != "" {
+		mu.Lock()
+		transaction := transactions[transactionID]
+		transaction.PaymentID = paymentResp.PaymentID
+		transactions[transactionID] = transaction
+		mu.Unlock()
+	}
+
+	if !paymentResp.Success {
+		updateStepStatus(transactionID, step, false, paymentResp.Message)
+		return paymentResp, errors.New(paymentResp.Message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Payment processed for order: %s with status %s\n", orderID, paymentResp.Status)
+	return paymentResp, nil
+}
+
+func awaitPaymentConfirmation(transactionID, paymentID string) error {
+	return awaitStep(transactionID, "AWAIT_PAYMENT_CONFIRMATION", PaymentConfirmationTimeout, func() (bool, error) {
+		resp, err := http.Get(fmt.Sprintf("%s/payment-status?payment_id=%s", PaymentServiceURL, paymentID))
+		if err != nil {
+			fmt.Printf("Failed to poll payment %s: %v\n", paymentID, err)
+			return false, nil
+		}
+		defer resp.Body.Close()
+
+		var paymentResp PaymentResponse
+		if err := json.NewDecoder(resp.Body).Decode(&paymentResp); err != nil {
+			fmt.Printf("Failed to poll payment %s: %v\n", paymentID, err)
+			return false, nil
+		}
+
+		switch paymentResp.Status {
+		case PaymentStatusSuccess:
+			return true, nil
+		case PaymentStatusPending:
+			return false, nil
+		default:
+			return false, fmt.Errorf("payment %s ended with status %s: %s", paymentID, paymentResp.Status, paymentResp.Message)
+		}
+	})
+}
+
+func awaitStep(transactionID, stepName string, timeout time.Duration, check func() (bool, error)) error {
+	step := addStep(transactionID, stepName)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		done, err := check()
+		if err != nil {
+			updateStepStatus(transactionID, step, false, err.Error())
+			return err
+		}
+		if done {
+			updateStepStatus(transactionID, step, true, "")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			err := fmt.Errorf("timed out after %s", timeout)
+			updateStepStatus(transactionID, step, false, err.Error())
+			return err
+		}
+		time.Sleep(PollInterval)
+	}
+}
+
+func selectCarrier(transactionID string, address Address, weightGrams int, preference string) (ShippingRate, error) {
+	step := addStep(transactionID, "SELECT_CARRIER")
+
+	query := url.Values{}
+	query.Set("country", address.Country)
+	query.Set("weight_grams", fmt.Sprint(weightGrams))
+	query.Set("preference", preference)
+
+	resp, err := http.Get(ShippingServiceURL + "/shipping-quotes?" + query.Encode())
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return ShippingRate{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return ShippingRate{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := fmt.Sprintf("shipping service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		updateStepStatus(transactionID, step, false, message)
+		return ShippingRate{}, errors.New(message)
+	}
+
+	var quotesResp ShippingQuotesResponse
+	if err := json.Unmarshal(body, &quotesResp); err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return ShippingRate{}, err
+	}
+	if len(quotesResp.Rates) == 0 {
+		updateStepStatus(transactionID, step, false, "no carrier rates available")
+		return ShippingRate{}, errors.New("no carrier rates available")
+	}
+
+	rate := quotesResp.Rates[0]
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Carrier selected (%s): %s %s at %s, %d-%d days\n", preference, rate.Carrier, rate.ServiceLevel, rate.Price, rate.MinDays, rate.MaxDays)
+	return rate, nil
+}
+
+func planShipments(transactionID, orderID string, address Address, items []Item) ([]Shipment, error) {
+	step := addStep(transactionID, "PLAN_SHIPMENTS")
+
+	planItems := make([]ShipmentItem, 0, len(items))
+	for _, item := range items {
+		planItems = append(planItems, ShipmentItem{
+			ID:          item.ID,
+			Quantity:    item.Quantity,
+			WeightGrams: item.WeightGrams,
+		})
+	}
+	planReq := map[string]interface{}{
+		"order_id": orderID,
+		"address":  address,
+		"items":    planItems,
+	}
+	reqBody, err := json.Marshal(planReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return nil, err
+	}
+
+	resp, err := http.Post(ShippingServiceURL+"/plan-shipments", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := fmt.Sprintf("shipping service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		updateStepStatus(transactionID, step, false, message)
+		return nil, errors.New(message)
+	}
+
+	var planResp PlanShipmentsResponse
+	if err := json.Unmarshal(body, &planResp); err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return nil, err
+	}
+	if !planResp.Success || len(planResp.Shipments) == 0 {
+		message := "shipping service did not plan any shipments"
+		if planResp.Message != "" {
+			message = planResp.Message
+		}
+		updateStepStatus(transactionID, step, false, message)
+		return nil, errors.New(message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	for _, shipment := range planResp.Shipments {
+		fmt.Printf("Shipment planned for order %s from %s (%d g)\n", orderID, shipment.WarehouseID, shipment.WeightGrams)
+	}
+	return planResp.Shipments, nil
+}
+
+func shipmentStock(shipments []Shipment) []StockItem {
+	var items []StockItem
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			items = append(items, StockItem{ID: item.ID, WarehouseID: shipment.WarehouseID, Quantity: item.Quantity})
+		}
+	}
+	return items
+}
+
+func returnedStock(items []Item, shipments []Shipment) ([]StockItem, error) {
+	var stockItems []StockItem
+	for _, item := range items {
+		remaining := item.Quantity
+		for _, shipment := range shipments {
+			for _, shipped := range shipment.Items {
+				if remaining == 0 || shipped.ID != item.ID {
+					continue
+				}
+				quantity := shipped.Quantity
+				if quantity > remaining {
+					quantity = remaining
+				}
+				stockItems = append(stockItems, StockItem{ID: item.ID, WarehouseID: shipment.WarehouseID, Quantity: quantity})
+				remaining -= quantity
+			}
+		}
+		if remaining > 0 {
+			return nil, fmt.Errorf("%w: %d of %s was not shipped from any warehouse", ErrRequestRejected, remaining, item.ID)
+		}
+	}
+	return stockItems, nil
+}
+
+func startShipments(transactionID, orderID string, address Address, shipments []Shipment) error {
+	for i := range shipments {
+		shippingID, err := startShipping(transactionID, orderID, address, shipments[i])
+		if err != nil {
+			if cancelErr := cancelShipments(transactionID, orderID, shipments[:i]); cancelErr != nil {
+				return fmt.Errorf("shipment %d of %d from %s: %w (%v)", i+1, len(shipments), shipments[i].WarehouseID, err, cancelErr)
+			}
+			return fmt.Errorf("shipment %d of %d from %s: %w", i+1, len(shipments), shipments[i].WarehouseID, err)
+		}
+		shipments[i].ShippingID = shippingID
+	}
+	return nil
+}
+
+func cancelShipments(transactionID, orderID string, shipments []Shipment) error {
+	var failed []string
+	for _, shipment := range shipments {
+		if shipment.ShippingID == "" {
+			continue
+		}
+		if err := cancelShipping(transactionID, orderID, shipment.ShippingID); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", shipment.ShippingID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to cancel shipments: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func restoreShipments(c *sagaContext, cancelled []int) error {
+	var restored []Shipment
+	var failed []string
+	for _, i := range cancelled {
+		shipment := c.PreviousShipments[i]
+		shippingID, err := startShipping(c.TransactionID, c.OrderID, c.PreviousAddress, shipment)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", shipment.ShippingID, err))
+			continue
+		}
+		fmt.Printf("Shipment %s restored as %s for order %s\n", shipment.ShippingID, shippingID, c.OrderID)
+		c.PreviousShipments[i].ShippingID = shippingID
+		restored = append(restored, c.PreviousShipments[i])
+	}
+	if len(cancelled) > 0 {
+		recordShipments(c.TransactionID, restored)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore cancelled shipments: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func recordShipments(transactionID string, shipments []Shipment) {
+	mu.Lock()
+	transaction := transactions[transactionID]
+	transaction.Shipments = append([]Shipment(nil), shipments...)
+	transactions[transactionID] = transaction
+	mu.Unlock()
+}
+
+func startShipping(transactionID, orderID string, address Address, shipment Shipment) (string, error) {
+	step := addStep(transactionID, "START_SHIPPING")
+
+	shippingReq := map[string]interface{}{
+		"order_id":      orderID,
+		"address":       address,
+		"warehouse_id":  shipment.WarehouseID,
+		"items":         shipment.Items,
+		"weight_grams":  shipment.WeightGrams,
+		"carrier":       shipment.Carrier,
+		"service_level": shipment.ServiceLevel,
+	}
+	reqBody, err := json.Marshal(shippingReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return "", err
+	}
+
+	resp, err := http.Post(ShippingServiceURL+"/start-shipping", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return "", err
+	}
+
+	var shippingResp ShippingResponse
+	if err := json.Unmarshal(body, &shippingResp); err != nil {
+		message := fmt.Sprintf("shipping service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		updateStepStatus(transactionID, step, false, message)
+		return "", errors.New(message)
+	}
+
+	if !shippingResp.Success {
+		updateStepStatus(transactionID, step, false, shippingResp.Message)
+		return shippingResp.ShippingID, errors.New(shippingResp.Message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Shipping initiated for order: %s (%s from %s via %s %s)\n", orderID, shippingResp.ShippingID, shipment.WarehouseID, shippingResp.Carrier, shippingResp.ServiceLevel)
+	return shippingResp.ShippingID, nil
+}
+
+func updateShipping(transactionID, orderID string, address Address) error {
+	step := addStep(transactionID, "UPDATE_SHIPPING")
+
+	updateReq := map[string]interface{}{
+		"order_id": orderID,
+		"address":  address,
+	}
+	reqBody, err := json.Marshal(updateReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(ShippingServiceURL+"/update-shipping", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(string(body))
+		updateStepStatus(transactionID, step, false, message)
+		return responseError(resp, message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Shipping address updated for order: %s\n", orderID)
+	return nil
+}
+
+func shippingStatus(orderID string) (ShippingResponse, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/shipping-status?order_id=%s", ShippingServiceURL, orderID))
+	if err != nil {
+		return ShippingResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ShippingResponse{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return ShippingResponse{}, fmt.Errorf("shipping service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var shippingResp ShippingResponse
+	if err := json.Unmarshal(body, &shippingResp); err != nil {
+		return ShippingResponse{}, err
+	}
+	return shippingResp, nil
+}
+
+func cancelOrder(transactionID, orderID string) error {
+	step := addStep(transactionID, "CANCEL_ORDER")
+
+	cancelReq := map[string]interface{}{
+		"order_id": orderID,
+	}
+	reqBody, err := json.Marshal(cancelReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(OrderServiceURL+"/cancel-order", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := statusError("order service", resp)
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Order cancelled: %s\n", orderID)
+	return nil
+}
+
+func refundPayment(transactionID, orderID, paymentID string) error {
+	step := addStep(transactionID, "REFUND_PAYMENT")
+
+	refundReq := map[string]interface{}{
+		"order_id":   orderID,
+		"payment_id": paymentID,
+	}
+	reqBody, err := json.Marshal(refundReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(PaymentServiceURL+"/refund-payment", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		updateStepStatus(transactionID, step, true, "")
+		fmt.Printf("No charged payment left to refund for order: %s\n", orderID)
+		return nil
+	}
+	if resp.StatusCode == http.StatusConflict {
+		body, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("payment service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("%w: %s", statusError("payment service", resp), strings.TrimSpace(string(body)))
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Payment refunded for order: %s\n", orderID)
+	return nil
+}
+
+func refundAmount(transactionID, stepName, orderID, paymentID string, amount Money) error {
+	step := addStep(transactionID, stepName)
+
+	refundReq := map[string]interface{}{
+		"order_id":   orderID,
+		"payment_id": paymentID,
+		"amount":     amount,
+	}
+	reqBody, err := json.Marshal(refundReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(PaymentServiceURL+"/refund-payment", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	var paymentResp PaymentResponse
+	if err := json.Unmarshal(body, &paymentResp); err != nil {
+		message := fmt.Sprintf("payment service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		updateStepStatus(transactionID, step, false, message)
+		return responseError(resp, message)
+	}
+
+	if !paymentResp.Success {
+		updateStepStatus(transactionID, step, false, paymentResp.Message)
+		return errors.New(paymentResp.Message)
+	}
+
+	mu.Lock()
+	transaction := transactions[transactionID]
+	transaction.Refunds = append(transaction.Refunds, Refund{PaymentID: paymentResp.PaymentID, Amount: amount})
+	transactions[transactionID] = transaction
+	mu.Unlock()
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Refunded %s of payment %s for order: %s\n", amount, paymentResp.PaymentID, orderID)
+	return nil
+}
+
+func refundOrderPayments(c *sagaContext, stepName string, amount Money) error {
+	if c.Refunded.Currency == "" {
+		c.Refunded = Money{Currency: amount.Currency}
+	}
+	outstanding, err := amount.Sub(c.Refunded)
+	if err != nil {
+		return err
+	}
+
+	payments, err := fetchPayments(c.OrderID)
+	if err != nil {
+		return err
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		if len(payments[i].ID) != len(payments[j].ID) {
+			return len(payments[i].ID) > len(payments[j].ID)
+		}
+		return payments[i].ID > payments[j].ID
+	})
+
+	for _, p := range payments {
+		if !outstanding.IsPositive() {
+			break
+		}
+		if p.Status != PaymentStatusSuccess && p.Status != PaymentStatusPartiallyRefunded {
+			continue
+		}
+		remaining, err := p.Amount.Sub(p.RefundedAmount)
+		if err != nil {
+			return err
+		}
+		if !remaining.IsPositive() {
+			continue
+		}
+		refund := outstanding
+		if cmp, err := refund.Cmp(remaining); err != nil {
+			return err
+		} else if cmp > 0 {
+			refund = remaining
+		}
+
+		if err := refundAmount(c.TransactionID, stepName, c.OrderID, p.ID, refund); err != nil {
+			return err
+		}
+		c.Refunded, _ = c.Refunded.Add(refund)
+		outstanding, _ = outstanding.Sub(refund)
+	}
+
+	if outstanding.IsPositive() {
+		return fmt.Errorf("%w: order %s has no refundable payments left for %s", ErrRequestRejected, c.OrderID, outstanding)
+	}
+	return nil
+}
+
+func fetchPayment(orderID, paymentID string) (Payment, error) {
+	payments, err := fetchPayments(orderID)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	for _, p := range payments {
+		if p.ID == paymentID {
+			return p, nil
+		}
+	}
+	return Payment{}, fmt.Errorf("payment %s not found for order %s", paymentID, orderID)
+}
+
+func fetchPayments(orderID string) ([]Payment, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/payments?order_id=%s", PaymentServiceURL, orderID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var paymentList PaymentListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&paymentList); err != nil {
+		return nil, err
+	}
+	return paymentList.Payments, nil
+}
+
+func refundOrder(transactionID, orderID string) error {
+	step := addStep(transactionID, "REFUND_ORDER")
+
+	if err := updateOrderStatus(orderID, OrderStatusRefunded, "payment refunded by saga compensation"); err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Order refunded: %s\n", orderID)
+	return nil
+}
+
+func updateOrderStatus(orderID, status, reason string) error {
+	updateReq := map[string]interface{}{
+		"order_id": orderID,
+		"status":   status,
+		"reason":   reason,
+	}
+	reqBody, err := json.Marshal(updateReq)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(OrderServiceURL+"/update-order-status", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var orderResp OrderResponse
+	if err := json.Unmarshal(body, &orderResp); err != nil {
+		return responseError(resp, fmt.Sprintf("order service returned %s: %s", resp.Status, strings.TrimSpace(string(body))))
+	}
+	if !orderResp.Success {
+		return responseError(resp, orderResp.Message)
+	}
+	return nil
+}
+
+func releaseStock(transactionID, orderID, reference string) error {
+	step := addStep(transactionID, "RELEASE_STOCK")
+
+	releaseReq := map[string]interface{}{
+		"order_id":  orderID,
+		"reference": reference,
+	}
+	reqBody, err := json.Marshal(releaseReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(InventoryServiceURL+"/release-stock", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := statusError("inventory service", resp)
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Stock released for order: %s\n", orderID)
+	return nil
+}
+
+func releaseCoupon(transactionID, orderID, couponCode string) error {
+	if couponCode == "" {
+		return nil
+	}
+
+	step := addStep(transactionID, "RELEASE_COUPON")
+
+	releaseReq := map[string]interface{}{
+		"order_id": orderID,
+	}
+	reqBody, err := json.Marshal(releaseReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	resp, err := http.Post(OrderServiceURL+"/release-coupon", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := statusError("order service", resp)
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
 
-func main() {
-	http.HandleFunc("/create-order-saga", createOrderSagaHandler)
-	http.HandleFunc("/transaction-status", transactionStatusHandler)
+	updateStepStatus(transactionID, step, true, "")
 
-	fmt.Println("Saga Orchestrator started on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	fmt.Printf("Coupon released for order: %s\n", orderID)
+	return nil
 }
 
-func createOrderSagaHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+func cancelShipping(transactionID, orderID, shippingID string) error {
+	step := addStep(transactionID, "CANCEL_SHIPPING")
+
+	cancelReq := map[string]interface{}{
+		"order_id":    orderID,
+		"shipping_id": shippingID,
+	}
+	reqBody, err := json.Marshal(cancelReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 
-	var req CreateOrderRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+	resp, err := http.Post(ShippingServiceURL+"/cancel-shipping", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
+	defer resp.Body.Close()
 
-	if req.CustomerID == "" {
-		http.Error(w, "Customer ID is required", http.StatusBadRequest)
-		return
+	if resp.StatusCode == http.StatusNotFound && shippingID != "" {
+		updateStepStatus(transactionID, step, true, "")
+		fmt.Printf("Shipping already cancelled for order: %s (%s)\n", orderID, shippingID)
+		return nil
 	}
-	if req.Amount <= 0 {
-		http.Error(w, "Amount must be greater than zero", http.StatusBadRequest)
-		return
+	if resp.StatusCode == http.StatusConflict {
+		var shippingResp ShippingResponse
+		json.NewDecoder(resp.Body).Decode(&shippingResp)
+		err := fmt.Errorf("%w: %s", ErrShipmentHandedOver, shippingResp.Message)
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
-	if req.Address == "" {
-		http.Error(w, "Shipping address is required", http.StatusBadRequest)
-		return
+	if resp.StatusCode != http.StatusOK {
+		err := statusError("shipping service", resp)
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 
-	mu.Lock()
-	transactionID := fmt.Sprintf("TRX-%d", nextID)
-	nextID++
+	updateStepStatus(transactionID, step, true, "")
 
-	transaction := Transaction{
-		ID:         transactionID,
-		CustomerID: req.CustomerID,
-		Amount:     req.Amount,
-		Address:    req.Address,
-		Status:     TransactionStatusPending,
-		CreatedAt:  time.Now(),
-		Steps:      []Step{},
-	}
-	transactions[transactionID] = transaction
-	mu.Unlock()
+	fmt.Printf("Shipping cancelled for order: %s (%s)\n", orderID, shippingID)
+	return nil
+}
 
-	go executeSaga(transactionID, req)
+func returnToSender(transactionID, orderID, shippingID string) error {
+	step := addStep(transactionID, "RETURN_TO_SENDER")
 
-	resp := TransactionResponse{
-		Success:     true,
-		Message:     "Transaction initiated successfully",
-		Transaction: transaction,
+	returnReq := map[string]interface{}{
+		"order_id":    orderID,
+		"shipping_id": shippingID,
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(resp)
-
-	fmt.Printf("Transaction initiated: %s\n", transactionID)
-}
-
-func transactionStatusHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	reqBody, err := json.Marshal(returnReq)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 
-	transactionID := r.URL.Query().Get("transaction_id")
-	if transactionID == "" {
-		http.Error(w, "Transaction ID is required", http.StatusBadRequest)
-		return
+	resp, err := http.Post(ShippingServiceURL+"/return-to-sender", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
+	defer resp.Body.Close()
 
-	mu.Lock()
-	transaction, exists := transactions[transactionID]
-	mu.Unlock()
-	if !exists {
-		http.Error(w, "Transaction not found", http.StatusNotFound)
-		return
+	var shippingResp ShippingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&shippingResp); err != nil {
+		err := statusError("shipping service", resp)
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
-
-	resp := TransactionResponse{
-		Success:     true,
-		Transaction: transaction,
+	if !shippingResp.Success {
+		updateStepStatus(transactionID, step, false, shippingResp.Message)
+		return responseError(resp, shippingResp.Message)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Return to sender requested for order: %s (%s)\n", orderID, shippingID)
+	return nil
 }
 
-func executeSaga(transactionID string, req CreateOrderRequest) {
-	orderID, err := createOrder(transactionID, req)
+func restockItems(transactionID, orderID string, items []StockItem) error {
+	step := addStep(transactionID, "RESTOCK_ITEMS")
+
+	restockReq := map[string]interface{}{
+		"order_id":  orderID,
+		"reference": transactionID + "/RESTOCK_ITEMS",
+		"items":     items,
+	}
+	reqBody, err := json.Marshal(restockReq)
 	if err != nil {
-		updateTransactionStatus(transactionID, TransactionStatusFailed, fmt.Sprintf("Failed to create order: %v", err))
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 
-	mu.Lock()
-	transaction := transactions[transactionID]
-	transaction.OrderID = orderID
-	transactions[transactionID] = transaction
-	mu.Unlock()
-
-	err = processPayment(transactionID, orderID, req.Amount)
+	resp, err := http.Post(InventoryServiceURL+"/restock", "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
-		cancelOrder(transactionID, orderID)
-		updateTransactionStatus(transactionID, TransactionStatusFailed, fmt.Sprintf("Failed to process payment: %v", err))
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
+	defer resp.Body.Close()
 
-	err = startShipping(transactionID, orderID, req.Address)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		refundPayment(transactionID, orderID)
-		cancelOrder(transactionID, orderID)
-		updateTransactionStatus(transactionID, TransactionStatusFailed, fmt.Sprintf("Failed to start shipping: %v", err))
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("%w: %s", statusError("inventory service", resp), strings.TrimSpace(string(body)))
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 
-	updateTransactionStatus(transactionID, TransactionStatusCompleted, "")
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Items restocked for order: %s\n", orderID)
+	return nil
 }
 
-func createOrder(transactionID string, req CreateOrderRequest) (string, error) {
-	addStep(transactionID, "CREATE_ORDER")
+func amendOrder(c *sagaContext) error {
+	step := addStep(c.TransactionID, "AMEND_ORDER")
 
-	orderReq := map[string]interface{}{
-		"customer_id": req.CustomerID,
-		"items":       req.Items,
-		"amount":      req.Amount,
-	}
-	reqBody, err := json.Marshal(orderReq)
+	reqBody, err := json.Marshal(c.Amendment)
 	if err != nil {
-		updateStepStatus(transactionID, "CREATE_ORDER", false, err.Error())
-		return "", err
+		updateStepStatus(c.TransactionID, step, false, err.Error())
+		return err
 	}
 
-	resp, err := http.Post(OrderServiceURL+"/create-order", "application/json", bytes.NewBuffer(reqBody))
+	resp, err := http.Post(OrderServiceURL+"/amend-order", "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
-		updateStepStatus(transactionID, "CREATE_ORDER", false, err.Error())
-		return "", err
+		updateStepStatus(c.TransactionID, step, false, err.Error())
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		updateStepStatus(transactionID, "CREATE_ORDER", false, err.Error())
-		return "", err
+		updateStepStatus(c.TransactionID, step, false, err.Error())
+		return err
 	}
 
-	var orderResp OrderResponse
-	if err := json.Unmarshal(body, &orderResp); err != nil {
-		updateStepStatus(transactionID, "CREATE_ORDER", false, err.Error())
-		return "", err
+	var amendResp AmendOrderResponse
+	if err := json.Unmarshal(body, &amendResp); err != nil {
+		message := fmt.Sprintf("order service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		updateStepStatus(c.TransactionID, step, false, message)
+		return errors.New(message)
 	}
 
-	if !orderResp.Success {
-		updateStepStatus(transactionID, "CREATE_ORDER", false, orderResp.Message)
-		return "", fmt.Errorf(orderResp.Message)
+	if !amendResp.Success {
+		updateStepStatus(c.TransactionID, step, false, amendResp.Message)
+		return errors.New(amendResp.Message)
+	}
+	if amendResp.Amount == nil || amendResp.PreviousAmount == nil {
+		updateStepStatus(c.TransactionID, step, false, "order service did not return the totals")
+		return errors.New("order service did not return the totals")
 	}
 
-	updateStepStatus(transactionID, "CREATE_ORDER", true, "")
+	c.AmendmentID = amendResp.AmendmentID
+	c.PreviousAmount = *amendResp.PreviousAmount
+	c.PreviousAddress = amendResp.PreviousAddress
+	c.Order = CreateOrderRequest{
+		CustomerID: amendResp.CustomerID,
+		Items:      amendResp.Items,
+		Amount:     amendResp.Amount,
+		Address:    amendResp.Address,
+	}
+	c.WeightGrams = amendResp.WeightGrams
+	c.Increases, c.Decreases = quantityChanges(amendResp.PreviousItems, amendResp.Items)
+
+	mu.Lock()
+	transaction := transactions[c.TransactionID]
+	transaction.AmendmentID = c.AmendmentID
+	transaction.CustomerID = c.Order.CustomerID
+	transaction.Amount = *c.Order.Amount
+	transaction.Address = c.Order.Address
+	transactions[c.TransactionID] = transaction
+	mu.Unlock()
+
+	updateStepStatus(c.TransactionID, step, true, "")
 
-	fmt.Printf("Order created: %s\n", orderResp.OrderID)
-	return orderResp.OrderID, nil
+	fmt.Printf("Order amended: %s with %s, total %s -> %s\n", c.OrderID, c.AmendmentID, c.PreviousAmount, c.Order.Amount)
+	return nil
 }
 
-func processPayment(transactionID, orderID string, amount float64) error {
-	addStep(transactionID, "PROCESS_PAYMENT")
+func revertAmendment(transactionID, orderID, amendmentID string) error {
+	step := addStep(transactionID, "REVERT_ORDER_AMENDMENT")
 
-	paymentReq := map[string]interface{}{
-		"order_id": orderID,
-		"amount":   amount,
+	revertReq := map[string]interface{}{
+		"order_id":     orderID,
+		"amendment_id": amendmentID,
 	}
-	reqBody, err := json.Marshal(paymentReq)
+	reqBody, err := json.Marshal(revertReq)
 	if err != nil {
-		updateStepStatus(transactionID, "PROCESS_PAYMENT", false, err.Error())
+		updateStepStatus(transactionID, step, false, err.Error())
 		return err
 	}
 
-	resp, err := http.Post(PaymentServiceURL+"/process-payment", "application/json", bytes.NewBuffer(reqBody))
+	resp, err := http.Post(OrderServiceURL+"/revert-amendment", "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
-		updateStepStatus(transactionID, "PROCESS_PAYMENT", false, err.Error())
+		updateStepStatus(transactionID, step, false, err.Error())
 		return err
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		err := statusError("order service", resp)
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Amendment %s reverted for order: %s\n", amendmentID, orderID)
+	return nil
+}
+
+func openReturn(c *sagaContext) error {
+	step := addStep(c.TransactionID, "OPEN_RETURN")
+
+	reqBody, err := json.Marshal(c.Return)
 	if err != nil {
-		updateStepStatus(transactionID, "PROCESS_PAYMENT", false, err.Error())
+		updateStepStatus(c.TransactionID, step, false, err.Error())
 		return err
 	}
 
-	var paymentResp PaymentResponse
-	if err := json.Unmarshal(body, &paymentResp); err != nil {
-		updateStepStatus(transactionID, "PROCESS_PAYMENT", false, err.Error())
+	resp, err := http.Post(OrderServiceURL+"/open-return", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		updateStepStatus(c.TransactionID, step, false, err.Error())
 		return err
 	}
+	defer resp.Body.Close()
 
-	if !paymentResp.Success {
-		updateStepStatus(transactionID, "PROCESS_PAYMENT", false, paymentResp.Message)
-		return fmt.Errorf(paymentResp.Message)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(c.TransactionID, step, false, err.Error())
+		return err
+	}
+
+	var returnResp ReturnResponse
+	if err := json.Unmarshal(body, &returnResp); err != nil {
+		message := fmt.Sprintf("order service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		updateStepStatus(c.TransactionID, step, false, message)
+		return errors.New(message)
+	}
+
+	if !returnResp.Success {
+		updateStepStatus(c.TransactionID, step, false, returnResp.Message)
+		return errors.New(returnResp.Message)
+	}
+	if returnResp.Amount == nil {
+		updateStepStatus(c.TransactionID, step, false, "order service did not return a refund amount")
+		return errors.New("order service did not return a refund amount")
 	}
 
-	updateStepStatus(transactionID, "PROCESS_PAYMENT", true, "")
+	c.ReturnID = returnResp.ReturnID
+	c.ReturnAmount = *returnResp.Amount
+	c.Order.CustomerID = returnResp.CustomerID
+	c.Order.Address = returnResp.Address
+	c.WeightGrams = returnResp.WeightGrams
+
+	mu.Lock()
+	transaction := transactions[c.TransactionID]
+	transaction.ReturnID = c.ReturnID
+	transaction.Amount = c.ReturnAmount
+	transactions[c.TransactionID] = transaction
+	mu.Unlock()
+
+	updateStepStatus(c.TransactionID, step, true, "")
 
-	fmt.Printf("Payment processed for order: %s\n", orderID)
+	fmt.Printf("Return opened: %s for order %s, refund %s\n", c.ReturnID, c.OrderID, c.ReturnAmount)
 	return nil
 }
 
-func startShipping(transactionID, orderID, address string) error {
-	addStep(transactionID, "START_SHIPPING")
+func completeReturn(transactionID, orderID, returnID string) error {
+	step := addStep(transactionID, "COMPLETE_RETURN")
 
-	shippingReq := map[string]interface{}{
-		"order_id": orderID,
-		"address":  address,
+	completeReq := map[string]interface{}{
+		"order_id":  orderID,
+		"return_id": returnID,
 	}
-	reqBody, err := json.Marshal(shippingReq)
+	reqBody, err := json.Marshal(completeReq)
 	if err != nil {
-		updateStepStatus(transactionID, "START_SHIPPING", false, err.Error())
+		updateStepStatus(transactionID, step, false, err.Error())
 		return err
 	}
 
-	resp, err := http.Post(ShippingServiceURL+"/start-shipping", "application/json", bytes.NewBuffer(reqBody))
+	resp, err := http.Post(OrderServiceURL+"/complete-return", "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
-		updateStepStatus(transactionID, "START_SHIPPING", false, err.Error())
+		updateStepStatus(transactionID, step, false, err.Error())
 		return err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		updateStepStatus(transactionID, "START_SHIPPING", false, err.Error())
-		return err
-	}
-
-	var shippingResp ShippingResponse
-	if err := json.Unmarshal(body, &shippingResp); err != nil {
-		updateStepStatus(transactionID, "START_SHIPPING", false, err.Error())
+		updateStepStatus(transactionID, step, false, err.Error())
 		return err
 	}
 
-	if !shippingResp.Success {
-		updateStepStatus(transactionID, "START_SHIPPING", false, shippingResp.Message)
-		return fmt.Errorf(shippingResp.Message)
+	if resp.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(string(body))
+		updateStepStatus(transactionID, step, false, message)
+		return errors.New(message)
 	}
 
-	updateStepStatus(transactionID, "START_SHIPPING", true, "")
+	updateStepStatus(transactionID, step, true, "")
 
-	fmt.Printf("Shipping initiated for order: %s\n", orderID)
+	fmt.Printf("Return %s completed for order: %s\n", returnID, orderID)
 	return nil
 }
 
-func cancelOrder(transactionID, orderID string) {
-	addStep(transactionID, "CANCEL_ORDER")
+func cancelReturn(transactionID, orderID, returnID string) error {
+	step := addStep(transactionID, "CANCEL_RETURN")
 
 	cancelReq := map[string]interface{}{
-		"order_id": orderID,
+		"order_id":  orderID,
+		"return_id": returnID,
 	}
 	reqBody, err := json.Marshal(cancelReq)
 	if err != nil {
-		updateStepStatus(transactionID, "CANCEL_ORDER", false, err.Error())
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 
-	resp, err := http.Post(OrderServiceURL+"/cancel-order", "application/json", bytes.NewBuffer(reqBody))
+	resp, err := http.Post(OrderServiceURL+"/cancel-return", "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
-		updateStepStatus(transactionID, "CANCEL_ORDER", false, err.Error())
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 	defer resp.Body.Close()
 
-	updateStepStatus(transactionID, "CANCEL_ORDER", true, "")
+	if resp.StatusCode != http.StatusOK {
+		err := statusError("order service", resp)
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
 
-	fmt.Printf("Order cancelled: %s\n", orderID)
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Return %s cancelled for order: %s\n", returnID, orderID)
+	return nil
 }
 
-func refundPayment(transactionID, orderID string) {
-	addStep(transactionID, "REFUND_PAYMENT")
+func startReturnShipping(transactionID, orderID, returnID string, address Address, weightGrams int) (string, error) {
+	step := addStep(transactionID, "START_RETURN_SHIPPING")
 
-	refundReq := map[string]interface{}{
-		"order_id": orderID,
+	shippingReq := map[string]interface{}{
+		"order_id":     orderID,
+		"address":      address,
+		"type":         ShippingTypeReturn,
+		"return_id":    returnID,
+		"weight_grams": weightGrams,
 	}
-	reqBody, err := json.Marshal(refundReq)
+	reqBody, err := json.Marshal(shippingReq)
 	if err != nil {
-		updateStepStatus(transactionID, "REFUND_PAYMENT", false, err.Error())
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return "", err
 	}
 
-	resp, err := http.Post(PaymentServiceURL+"/refund-payment", "application/json", bytes.NewBuffer(reqBody))
+	resp, err := http.Post(ShippingServiceURL+"/start-shipping", "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
-		updateStepStatus(transactionID, "REFUND_PAYMENT", false, err.Error())
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	updateStepStatus(transactionID, "REFUND_PAYMENT", true, "")
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return "", err
+	}
 
-	fmt.Printf("Payment refunded for order: %s\n", orderID)
+	var shippingResp ShippingResponse
+	if err := json.Unmarshal(body, &shippingResp); err != nil {
+		message := fmt.Sprintf("shipping service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		updateStepStatus(transactionID, step, false, message)
+		return "", errors.New(message)
+	}
+
+	if !shippingResp.Success {
+		updateStepStatus(transactionID, step, false, shippingResp.Message)
+		return shippingResp.ShippingID, errors.New(shippingResp.Message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Return shipping initiated for order: %s (%s)\n", orderID, shippingResp.ShippingID)
+	return shippingResp.ShippingID, nil
 }
 
-func cancelShipping(transactionID, orderID string) {
-	addStep(transactionID, "CANCEL_SHIPPING")
+func receiveReturnShipment(transactionID, shippingID string) error {
+	step := addStep(transactionID, "RECEIVE_RETURN")
 
-	cancelReq := map[string]interface{}{
-		"order_id": orderID,
+	receiveReq := map[string]interface{}{
+		"shipping_id": shippingID,
 	}
-	reqBody, err := json.Marshal(cancelReq)
+	reqBody, err := json.Marshal(receiveReq)
 	if err != nil {
-		updateStepStatus(transactionID, "CANCEL_SHIPPING", false, err.Error())
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 
-	resp, err := http.Post(ShippingServiceURL+"/cancel-shipping", "application/json", bytes.NewBuffer(reqBody))
+	resp, err := http.Post(ShippingServiceURL+"/receive-return", "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
-		updateStepStatus(transactionID, "CANCEL_SHIPPING", false, err.Error())
-		return
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
 	}
 	defer resp.Body.Close()
 
-	updateStepStatus(transactionID, "CANCEL_SHIPPING", true, "")
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		updateStepStatus(transactionID, step, false, err.Error())
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(string(body))
+		updateStepStatus(transactionID, step, false, message)
+		return errors.New(message)
+	}
+
+	updateStepStatus(transactionID, step, true, "")
+
+	fmt.Printf("Return shipment received: %s\n", shippingID)
+	return nil
+}
+
+func quantityChanges(previous, current []Item) ([]Item, []Item) {
+	quantities := make(map[string]int)
+	var order []string
+	for _, item := range current {
+		if _, seen := quantities[item.ID]; !seen {
+			order = append(order, item.ID)
+		}
+		quantities[item.ID] += item.Quantity
+	}
+	for _, item := range previous {
+		if _, seen := quantities[item.ID]; !seen {
+			order = append(order, item.ID)
+		}
+		quantities[item.ID] -= item.Quantity
+	}
 
-	fmt.Printf("Shipping cancelled for order: %s\n", orderID)
+	var increases, decreases []Item
+	for _, id := range order {
+		switch delta := quantities[id]; {
+		case delta > 0:
+			increases = append(increases, Item{ID: id, Quantity: delta})
+		case delta < 0:
+			decreases = append(decreases, Item{ID: id, Quantity: -delta})
+		}
+	}
+	return increases, decreases
 }
 
-func addStep(transactionID, stepName string) {
+func addStep(transactionID, stepName string) int {
 	mu.Lock()
 	defer mu.Unlock()
 
 	transaction, exists := transactions[transactionID]
 	if !exists {
-		return
+		return -1
 	}
 
 	step := Step{
@@ -437,32 +3441,30 @@ func addStep(transactionID, stepName string) {
 	transactions[transactionID] = transaction
 
 	fmt.Printf("Step added to transaction %s: %s\n", transactionID, stepName)
+	return len(transaction.Steps) - 1
 }
 
-func updateStepStatus(transactionID, stepName string, success bool, errorMsg string) {
+func updateStepStatus(transactionID string, index int, success bool, errorMsg string) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	transaction, exists := transactions[transactionID]
-	if !exists {
+	if !exists || index < 0 || index >= len(transaction.Steps) {
 		return
 	}
 
-	for i, step := range transaction.Steps {
-		if step.Name == stepName {
-			if success {
-				transaction.Steps[i].Status = TransactionStatusCompleted
-			} else {
-				transaction.Steps[i].Status = TransactionStatusFailed
-				transaction.Steps[i].Error = errorMsg
-			}
-			transaction.Steps[i].EndedAt = time.Now()
-			break
-		}
+	transaction.Steps = append([]Step(nil), transaction.Steps...)
+	step := &transaction.Steps[index]
+	if success {
+		step.Status = TransactionStatusCompleted
+	} else {
+		step.Status = TransactionStatusFailed
+		step.Error = errorMsg
 	}
+	step.EndedAt = time.Now()
 	transactions[transactionID] = transaction
 
-	fmt.Printf("Step status updated for transaction %s: %s - %v\n", transactionID, stepName, success)
+	fmt.Printf("Step status updated for transaction %s: %s - %v\n", transactionID, step.Name, success)
 }
 
 func updateTransactionStatus(transactionID, status, failureReason string) {
@@ -477,7 +3479,7 @@ func updateTransactionStatus(transactionID, status, failureReason string) {
 	transaction.Status = status
 	if status == TransactionStatusCompleted {
 		transaction.CompletedAt = time.Now()
-	} else if status == TransactionStatusFailed {
+	} else if status == TransactionStatusFailed || status == TransactionStatusCompensated || status == TransactionStatusNeedsAttention {
 		transaction.FailureReason = failureReason
 		transaction.CompletedAt = time.Now()
 	}
@@ -485,3 +3487,29 @@ func updateTransactionStatus(transactionID, status, failureReason string) {
 
 	fmt.Printf("Transaction status updated: %s - %s\n", transactionID, status)
 }
+
+func statusError(service string, resp *http.Response) error {
+	return responseError(resp, fmt.Sprintf("%s returned %s", service, resp.Status))
+}
+
+func responseError(resp *http.Response, message string) error {
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return fmt.Errorf("%w: %s", ErrRequestRejected, message)
+	}
+	return errors.New(message)
+}
+
+func writeAddressError(w http.ResponseWriter, message string, err error) {
+	resp := AddressValidationResponse{
+		Success: false,
+		Message: fmt.Sprintf("%s: %v", message, err),
+	}
+	var addressErr address.ValidationError
+	if errors.As(err, &addressErr) {
+		resp.Errors = addressErr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(resp)
+}
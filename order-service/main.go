@@ -2,56 +2,368 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/address"
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/money"
+)
+
+const (
+	OrderStatusPending           = "PENDING"
+	OrderStatusAwaitingPayment   = "AWAITING_PAYMENT"
+	OrderStatusPaid              = "PAID"
+	OrderStatusShipping          = "SHIPPING"
+	OrderStatusCompleted         = "COMPLETED"
+	OrderStatusCancelled         = "CANCELLED"
+	OrderStatusRefunded          = "REFUNDED"
+	OrderStatusPartiallyReturned = "PARTIALLY_RETURNED"
+	OrderStatusReturned          = "RETURNED"
+)
+
+var orderTransitions = map[string][]string{
+	OrderStatusPending:           {OrderStatusAwaitingPayment, OrderStatusCancelled},
+	OrderStatusAwaitingPayment:   {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:              {OrderStatusShipping, OrderStatusRefunded},
+	OrderStatusShipping:          {OrderStatusCompleted, OrderStatusRefunded},
+	OrderStatusCompleted:         {OrderStatusRefunded, OrderStatusPartiallyReturned, OrderStatusReturned},
+	OrderStatusPartiallyReturned: {OrderStatusPartiallyReturned, OrderStatusReturned},
+}
+
+const (
+	PromotionTypePercentage = "PERCENTAGE"
+	PromotionTypeFixed      = "FIXED"
+	PromotionTypeBuyXGetY   = "BUY_X_GET_Y"
+)
+
+const (
+	CouponStatusReserved = "RESERVED"
+	CouponStatusReleased = "RELEASED"
+	CouponStatusRedeemed = "REDEEMED"
+)
+
+const (
+	AmendmentStatusApplied  = "APPLIED"
+	AmendmentStatusReverted = "REVERTED"
+)
+
+const (
+	ReturnStatusRequested = "REQUESTED"
+	ReturnStatusCompleted = "COMPLETED"
+	ReturnStatusCancelled = "CANCELLED"
+)
+
+const (
+	CatalogFile    = "catalog.json"
+	PromotionsFile = "promotions.json"
+	TaxRatesFile   = "tax-rates.json"
 )
 
+const DefaultTaxCategory = "standard"
+
 const (
-	OrderStatusPending   = "PENDING"
-	OrderStatusCompleted = "COMPLETED"
-	OrderStatusCancelled = "CANCELLED"
+	DefaultPageSize = 20
+	MaxPageSize     = 100
 )
 
+type Money = money.Money
+
+var ErrIllegalTransition = errors.New("illegal order status transition")
+
 type Order struct {
-	ID         string  `json:"id"`
-	CustomerID string  `json:"customer_id"`
-	Amount     float64 `json:"amount"`
-	Status     string  `json:"status"`
-	Items      []Item  `json:"items"`
+	ID          string         `json:"id"`
+	CustomerID  string         `json:"customer_id"`
+	Subtotal    Money          `json:"subtotal"`
+	Discounts   []DiscountLine `json:"discounts,omitempty"`
+	Taxes       []TaxLine      `json:"taxes,omitempty"`
+	TaxTotal    Money          `json:"tax_total"`
+	Amount      Money          `json:"amount"`
+	WeightGrams int            `json:"weight_grams"`
+	CouponCode  string         `json:"coupon_code,omitempty"`
+	Address     Address        `json:"address"`
+	Status      string         `json:"status"`
+	History     []StatusChange `json:"history"`
+	Items       []Item         `json:"items"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+type Address = address.Address
+
+type StatusChange struct {
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type DiscountLine struct {
+	PromotionID string `json:"promotion_id"`
+	Code        string `json:"code,omitempty"`
+	Description string `json:"description"`
+	ProductID   string `json:"product_id,omitempty"`
+	Amount      Money  `json:"amount"`
+}
+
+type Promotion struct {
+	ID             string           `json:"id"`
+	Code           string           `json:"code,omitempty"`
+	Description    string           `json:"description"`
+	Type           string           `json:"type"`
+	Percent        int64            `json:"percent,omitempty"`
+	AmountOff      map[string]int64 `json:"amount_off,omitempty"`
+	ProductID      string           `json:"product_id,omitempty"`
+	BuyQuantity    int              `json:"buy_quantity,omitempty"`
+	FreeQuantity   int              `json:"free_quantity,omitempty"`
+	MinBasket      map[string]int64 `json:"min_basket,omitempty"`
+	MaxRedemptions int              `json:"max_redemptions,omitempty"`
+}
+
+type CouponReservation struct {
+	OrderID    string    `json:"order_id"`
+	Code       string    `json:"code"`
+	Status     string    `json:"status"`
+	ReservedAt time.Time `json:"reserved_at"`
 }
 
 type Item struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Price       Money  `json:"price"`
+	Quantity    int    `json:"quantity"`
+	WeightGrams int    `json:"weight_grams,omitempty"`
+}
+
+type ItemRequest struct {
+	ID       string `json:"id"`
+	Price    *Money `json:"price,omitempty"`
+	Quantity int    `json:"quantity"`
+}
+
+type Product struct {
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	Prices      map[string]int64 `json:"prices"`
+	TaxCategory string           `json:"tax_category"`
+	WeightGrams int              `json:"weight_grams"`
+}
+
+type TaxRate struct {
+	Region   string `json:"region"`
+	Category string `json:"category"`
+	Rate     string `json:"rate"`
+}
+
+type TaxLine struct {
+	Region   string `json:"region"`
+	Category string `json:"category"`
+	Rate     string `json:"rate"`
+	Taxable  Money  `json:"taxable"`
+	Amount   Money  `json:"amount"`
 }
 
 type CreateOrderRequest struct {
-	CustomerID string  `json:"customer_id"`
-	Items      []Item  `json:"items"`
-	Amount     float64 `json:"amount"`
+	CustomerID string        `json:"customer_id"`
+	Items      []ItemRequest `json:"items"`
+	Amount     *Money        `json:"amount,omitempty"`
+	Currency   string        `json:"currency,omitempty"`
+	CouponCode string        `json:"coupon_code,omitempty"`
+	Address    Address       `json:"address"`
 }
 
 type OrderResponse struct {
+	Success     bool           `json:"success"`
+	Message     string         `json:"message"`
+	OrderID     string         `json:"order_id,omitempty"`
+	Status      string         `json:"status,omitempty"`
+	Subtotal    *Money         `json:"subtotal,omitempty"`
+	Discounts   []DiscountLine `json:"discounts,omitempty"`
+	Taxes       []TaxLine      `json:"taxes,omitempty"`
+	TaxTotal    *Money         `json:"tax_total,omitempty"`
+	Amount      *Money         `json:"amount,omitempty"`
+	WeightGrams int            `json:"weight_grams,omitempty"`
+	Items       []Item         `json:"items,omitempty"`
+}
+
+type OrderPricing struct {
+	Items       []Item         `json:"items"`
+	Subtotal    Money          `json:"subtotal"`
+	Discounts   []DiscountLine `json:"discounts,omitempty"`
+	Taxes       []TaxLine      `json:"taxes,omitempty"`
+	TaxTotal    Money          `json:"tax_total"`
+	Amount      Money          `json:"amount"`
+	WeightGrams int            `json:"weight_grams"`
+}
+
+type Amendment struct {
+	ID              string       `json:"id"`
+	OrderID         string       `json:"order_id"`
+	Status          string       `json:"status"`
+	PreviousAddress Address      `json:"previous_address"`
+	Previous        OrderPricing `json:"previous"`
+	CreatedAt       time.Time    `json:"created_at"`
+}
+
+type AmendOrderRequest struct {
+	OrderID string        `json:"order_id"`
+	Items   []ItemRequest `json:"items,omitempty"`
+	Address *Address      `json:"address,omitempty"`
+}
+
+type AmendmentRequest struct {
+	OrderID     string `json:"order_id"`
+	AmendmentID string `json:"amendment_id"`
+}
+
+type AmendOrderResponse struct {
+	Success         bool     `json:"success"`
+	Message         string   `json:"message"`
+	OrderID         string   `json:"order_id,omitempty"`
+	CustomerID      string   `json:"customer_id,omitempty"`
+	AmendmentID     string   `json:"amendment_id,omitempty"`
+	PreviousAmount  *Money   `json:"previous_amount,omitempty"`
+	Amount          *Money   `json:"amount,omitempty"`
+	WeightGrams     int      `json:"weight_grams,omitempty"`
+	PreviousItems   []Item   `json:"previous_items,omitempty"`
+	Items           []Item   `json:"items,omitempty"`
+	PreviousAddress *Address `json:"previous_address,omitempty"`
+	Address         *Address `json:"address,omitempty"`
+}
+
+type Return struct {
+	ID        string    `json:"id"`
+	OrderID   string    `json:"order_id"`
+	Items     []Item    `json:"items"`
+	Amount    Money     `json:"amount"`
+	Reason    string    `json:"reason,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type OpenReturnRequest struct {
+	OrderID string `json:"order_id"`
+	Items   []Item `json:"items"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type ReturnRequest struct {
+	OrderID  string `json:"order_id"`
+	ReturnID string `json:"return_id"`
+}
+
+type ReturnResponse struct {
+	Success     bool     `json:"success"`
+	Message     string   `json:"message"`
+	ReturnID    string   `json:"return_id,omitempty"`
+	OrderID     string   `json:"order_id,omitempty"`
+	CustomerID  string   `json:"customer_id,omitempty"`
+	Address     *Address `json:"address,omitempty"`
+	Status      string   `json:"status,omitempty"`
+	OrderStatus string   `json:"order_status,omitempty"`
+	Amount      *Money   `json:"amount,omitempty"`
+	WeightGrams int      `json:"weight_grams,omitempty"`
+	Items       []Item   `json:"items,omitempty"`
+}
+
+type OrderDetailResponse struct {
+	Success bool     `json:"success"`
+	Order   Order    `json:"order"`
+	Returns []Return `json:"returns,omitempty"`
+}
+
+type OrderListResponse struct {
+	Success    bool    `json:"success"`
+	Orders     []Order `json:"orders"`
+	Page       int     `json:"page"`
+	PageSize   int     `json:"page_size"`
+	Total      int     `json:"total"`
+	TotalPages int     `json:"total_pages"`
+}
+
+type UpdateOrderStatusRequest struct {
+	OrderID string `json:"order_id"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type CouponRequest struct {
+	OrderID string `json:"order_id"`
+}
+
+type CouponResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	OrderID string `json:"order_id,omitempty"`
+	Code    string `json:"code,omitempty"`
 	Status  string `json:"status,omitempty"`
 }
 
+type ProductListResponse struct {
+	Success  bool      `json:"success"`
+	Products []Product `json:"products"`
+}
+
 var (
 	orders = make(map[string]Order)
 	mu     sync.Mutex
 	nextID = 1
+
+	catalog    map[string]Product
+	promotions []Promotion
+	taxRates   map[string]map[string]TaxRate
+
+	amendments      = make(map[string]Amendment)
+	nextAmendmentID = 1
+
+	returns      = make(map[string]Return)
+	nextReturnID = 1
+
+	couponUsage        = make(map[string]int)
+	couponReservations = make(map[string]CouponReservation)
 )
 
 func main() {
+	products, err := loadCatalog(CatalogFile)
+	if err != nil {
+		log.Fatalf("Failed to load catalog from %s: %v", CatalogFile, err)
+	}
+	catalog = products
+
+	promos, err := loadPromotions(PromotionsFile)
+	if err != nil {
+		log.Fatalf("Failed to load promotions from %s: %v", PromotionsFile, err)
+	}
+	promotions = promos
+
+	rates, err := loadTaxRates(TaxRatesFile)
+	if err != nil {
+		log.Fatalf("Failed to load tax rates from %s: %v", TaxRatesFile, err)
+	}
+	taxRates = rates
+
 	http.HandleFunc("/create-order", createOrderHandler)
 	http.HandleFunc("/cancel-order", cancelOrderHandler)
+	http.HandleFunc("/update-order-status", updateOrderStatusHandler)
+	http.HandleFunc("/amend-order", amendOrderHandler)
+	http.HandleFunc("/revert-amendment", revertAmendmentHandler)
+	http.HandleFunc("/open-return", openReturnHandler)
+	http.HandleFunc("/complete-return", completeReturnHandler)
+	http.HandleFunc("/cancel-return", cancelReturnHandler)
 	http.HandleFunc("/order-status", orderStatusHandler)
+	http.HandleFunc("/orders", listOrdersHandler)
+	http.HandleFunc("/orders/", orderDetailHandler)
+	http.HandleFunc("/products", productsHandler)
+	http.HandleFunc("/reserve-coupon", reserveCouponHandler)
+	http.HandleFunc("/release-coupon", releaseCouponHandler)
 
 	fmt.Println("Order Service started on :8081")
 	log.Fatal(http.ListenAndServe(":8081", nil))
@@ -69,39 +381,90 @@ func createOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.CustomerID == "" {
+		http.Error(w, "Customer ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "Order must contain at least one item", http.StatusBadRequest)
+		return
+	}
+
+	currency := req.Currency
+	if req.Amount != nil {
+		currency = req.Amount.Currency
+	}
+	if err := (Money{Currency: currency}).Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid order currency: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	couponCode := normalizeCouponCode(req.CouponCode)
+	pricing, err := priceOrder(req.Items, currency, couponCode, req.Address)
+	totalAmount := pricing.Amount
+	if err == nil && couponCode != "" {
+		mu.Lock()
+		err = checkCouponAvailable(couponCode)
+		mu.Unlock()
+	}
+	if err == nil && req.Amount != nil && *req.Amount != totalAmount {
+		err = fmt.Errorf("Order amount %s does not match catalog total %s", req.Amount, totalAmount)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(OrderResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
 	mu.Lock()
 	orderID := fmt.Sprintf("ORD-%d", nextID)
 	nextID++
 
-	totalAmount := req.Amount
-	if totalAmount == 0 {
-		for _, item := range req.Items {
-			totalAmount += item.Price * float64(item.Quantity)
-		}
-	}
-
+	now := time.Now()
 	order := Order{
-		ID:         orderID,
-		CustomerID: req.CustomerID,
-		Amount:     totalAmount,
-		Status:     OrderStatusPending,
-		Items:      req.Items,
+		ID:          orderID,
+		CustomerID:  req.CustomerID,
+		Subtotal:    pricing.Subtotal,
+		Discounts:   pricing.Discounts,
+		Taxes:       pricing.Taxes,
+		TaxTotal:    pricing.TaxTotal,
+		Amount:      totalAmount,
+		WeightGrams: pricing.WeightGrams,
+		CouponCode:  couponCode,
+		Address:     req.Address,
+		Status:      OrderStatusPending,
+		History: []StatusChange{
+			{To: OrderStatusPending, At: now},
+		},
+		Items:     pricing.Items,
+		CreatedAt: now,
 	}
 	orders[orderID] = order
 	mu.Unlock()
 
 	resp := OrderResponse{
-		Success: true,
-		Message: "Order created successfully",
-		OrderID: orderID,
-		Status:  OrderStatusPending,
+		Success:     true,
+		Message:     "Order created successfully",
+		OrderID:     orderID,
+		Status:      OrderStatusPending,
+		Subtotal:    &order.Subtotal,
+		Discounts:   order.Discounts,
+		Taxes:       order.Taxes,
+		TaxTotal:    &order.TaxTotal,
+		Amount:      &order.Amount,
+		WeightGrams: order.WeightGrams,
+		Items:       order.Items,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(resp)
 
-	fmt.Printf("Order created: %s with status %s\n", orderID, OrderStatusPending)
+	fmt.Printf("Order created: %s with status %s and total %s\n", orderID, OrderStatusPending, totalAmount)
 }
 
 func cancelOrderHandler(w http.ResponseWriter, r *http.Request) {
@@ -112,6 +475,7 @@ func cancelOrderHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		OrderID string `json:"order_id"`
+		Reason  string `json:"reason,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -126,7 +490,11 @@ func cancelOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	order.Status = OrderStatusCancelled
+	if err := transitionOrder(&order, OrderStatusCancelled, req.Reason); err != nil {
+		mu.Unlock()
+		writeTransitionError(w, req.OrderID, order.Status, err)
+		return
+	}
 	orders[req.OrderID] = order
 	mu.Unlock()
 
@@ -143,6 +511,52 @@ func cancelOrderHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("Order cancelled: %s\n", req.OrderID)
 }
 
+func updateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UpdateOrderStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !isOrderStatus(req.Status) {
+		http.Error(w, fmt.Sprintf("Unknown order status %q", req.Status), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	order, exists := orders[req.OrderID]
+	if !exists {
+		mu.Unlock()
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	if err := transitionOrder(&order, req.Status, req.Reason); err != nil {
+		mu.Unlock()
+		writeTransitionError(w, req.OrderID, order.Status, err)
+		return
+	}
+	orders[req.OrderID] = order
+	mu.Unlock()
+
+	resp := OrderResponse{
+		Success: true,
+		Message: "Order status updated successfully",
+		OrderID: req.OrderID,
+		Status:  order.Status,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Order status updated: %s to %s\n", req.OrderID, order.Status)
+}
+
 func orderStatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -163,26 +577,1155 @@ func orderStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	resp := OrderResponse{
+		Success:   true,
+		OrderID:   orderID,
+		Status:    order.Status,
+		Subtotal:  &order.Subtotal,
+		Discounts: order.Discounts,
+		Taxes:     order.Taxes,
+		TaxTotal:  &order.TaxTotal,
+		Amount:    &order.Amount,
+		Items:     order.Items,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func amendOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AmendOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Items) == 0 && req.Address == nil {
+		http.Error(w, "Items or address must be provided", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	order, exists := orders[req.OrderID]
+	mu.Unlock()
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if !isAmendable(order.Status) {
+		writeAmendError(w, http.StatusConflict, fmt.Errorf("Order %s is %s and cannot be amended", order.ID, order.Status))
+		return
+	}
+	mu.Lock()
+	openReturns := len(orderReturns(order.ID, ReturnStatusRequested))
+	mu.Unlock()
+	if openReturns > 0 {
+		writeAmendError(w, http.StatusConflict, fmt.Errorf("Order %s has an open return and cannot be amended", order.ID))
+		return
+	}
+
+	items := req.Items
+	if len(items) == 0 {
+		items = itemRequests(order.Items)
+	}
+	address := order.Address
+	if req.Address != nil {
+		address = *req.Address
+	}
+
+	pricing, err := priceOrder(items, order.Amount.Currency, order.CouponCode, address)
+	if err != nil {
+		writeAmendError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	mu.Lock()
+	current := orders[req.OrderID]
+	if current.Status != order.Status || current.Amount != order.Amount || current.Address != order.Address {
+		mu.Unlock()
+		writeAmendError(w, http.StatusConflict, fmt.Errorf("Order %s changed while it was being amended", order.ID))
+		return
+	}
+
+	amendmentID := fmt.Sprintf("AMD-%d", nextAmendmentID)
+	nextAmendmentID++
+
+	amendments[amendmentID] = Amendment{
+		ID:              amendmentID,
+		OrderID:         order.ID,
+		Status:          AmendmentStatusApplied,
+		PreviousAddress: order.Address,
+		Previous:        pricingOf(order),
+		CreatedAt:       time.Now(),
+	}
+
+	order.Items = pricing.Items
+	order.Subtotal = pricing.Subtotal
+	order.Discounts = pricing.Discounts
+	order.Taxes = pricing.Taxes
+	order.TaxTotal = pricing.TaxTotal
+	order.Amount = pricing.Amount
+	order.WeightGrams = pricing.WeightGrams
+	order.Address = address
+	orders[order.ID] = order
+	mu.Unlock()
+
+	previous := current.Amount
+	resp := AmendOrderResponse{
+		Success:         true,
+		Message:         "Order amended successfully",
+		OrderID:         order.ID,
+		CustomerID:      order.CustomerID,
+		AmendmentID:     amendmentID,
+		PreviousAmount:  &previous,
+		Amount:          &order.Amount,
+		WeightGrams:     order.WeightGrams,
+		PreviousItems:   current.Items,
+		Items:           order.Items,
+		PreviousAddress: &current.Address,
+		Address:         &order.Address,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Order amended: %s with %s, total %s -> %s\n", order.ID, amendmentID, previous, order.Amount)
+}
+
+func revertAmendmentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AmendmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	amendment, exists := amendments[req.AmendmentID]
+	if !exists || amendment.OrderID != req.OrderID {
+		mu.Unlock()
+		http.Error(w, "Amendment not found", http.StatusNotFound)
+		return
+	}
+	for _, other := range amendments {
+		if other.OrderID == amendment.OrderID && other.Status == AmendmentStatusApplied && other.CreatedAt.After(amendment.CreatedAt) {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Amendment %s must be reverted first", other.ID), http.StatusConflict)
+			return
+		}
+	}
+
+	if amendment.Status == AmendmentStatusApplied {
+		order := orders[amendment.OrderID]
+		order.Items = amendment.Previous.Items
+		order.Subtotal = amendment.Previous.Subtotal
+		order.Discounts = amendment.Previous.Discounts
+		order.Taxes = amendment.Previous.Taxes
+		order.TaxTotal = amendment.Previous.TaxTotal
+		order.Amount = amendment.Previous.Amount
+		order.WeightGrams = amendment.Previous.WeightGrams
+		order.Address = amendment.PreviousAddress
+		orders[order.ID] = order
+
+		amendment.Status = AmendmentStatusReverted
+		amendments[amendment.ID] = amendment
+	}
+	mu.Unlock()
+
 	resp := OrderResponse{
 		Success: true,
-		OrderID: orderID,
-		Status:  order.Status,
+		Message: "Amendment reverted successfully",
+		OrderID: amendment.OrderID,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Amendment reverted: %s for order %s\n", amendment.ID, amendment.OrderID)
 }
 
-func completeOrder(orderID string) bool {
+func isAmendable(status string) bool {
+	return status == OrderStatusPaid || status == OrderStatusShipping || status == OrderStatusCompleted
+}
+
+func writeAmendError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(AmendOrderResponse{
+		Success: false,
+		Message: err.Error(),
+	})
+}
+
+func openReturnHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OpenReturnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Items) == 0 {
+		http.Error(w, "At least one item must be returned", http.StatusBadRequest)
+		return
+	}
+
 	mu.Lock()
 	defer mu.Unlock()
 
-	order, exists := orders[orderID]
+	order, exists := orders[req.OrderID]
 	if !exists {
-		return false
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if order.Status != OrderStatusCompleted && order.Status != OrderStatusPartiallyReturned {
+		writeReturnError(w, http.StatusConflict, fmt.Errorf("Order %s is %s and cannot be returned", order.ID, order.Status))
+		return
+	}
+
+	kept := make(map[string]int)
+	for _, item := range order.Items {
+		kept[item.ID] += item.Quantity
+	}
+	refundable := order.Amount
+	for _, ret := range orderReturns(order.ID, "") {
+		if ret.Status == ReturnStatusCancelled {
+			continue
+		}
+		for _, item := range ret.Items {
+			kept[item.ID] -= item.Quantity
+		}
+		refundable, _ = refundable.Sub(ret.Amount)
 	}
 
-	order.Status = OrderStatusCompleted
+	var returned []Item
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			writeReturnError(w, http.StatusUnprocessableEntity, fmt.Errorf("Quantity for item %s must be greater than zero", item.ID))
+			return
+		}
+		if kept[item.ID] < item.Quantity {
+			writeReturnError(w, http.StatusUnprocessableEntity, fmt.Errorf("Only %d unit(s) of item %s can be returned", kept[item.ID], item.ID))
+			return
+		}
+		kept[item.ID] -= item.Quantity
+		returned = append(returned, Item{ID: item.ID, Name: catalog[item.ID].Name, Quantity: item.Quantity})
+	}
+
+	var keptItems []ItemRequest
+	for _, item := range order.Items {
+		if kept[item.ID] > 0 {
+			keptItems = append(keptItems, ItemRequest{ID: item.ID, Quantity: kept[item.ID]})
+			kept[item.ID] = 0
+		}
+	}
+
+	amount := refundable
+	if len(keptItems) > 0 {
+		pricing, err := priceOrder(keptItems, order.Amount.Currency, order.CouponCode, order.Address)
+		if err != nil && order.CouponCode != "" {
+			pricing, err = priceOrder(keptItems, order.Amount.Currency, "", order.Address)
+		}
+		if err != nil {
+			writeReturnError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		amount, _ = refundable.Sub(pricing.Amount)
+	}
+	if !amount.IsPositive() {
+		writeReturnError(w, http.StatusUnprocessableEntity, fmt.Errorf("Returning these items does not refund anything"))
+		return
+	}
+
+	returnID := fmt.Sprintf("RET-%d", nextReturnID)
+	nextReturnID++
+
+	now := time.Now()
+	ret := Return{
+		ID:        returnID,
+		OrderID:   order.ID,
+		Items:     returned,
+		Amount:    amount,
+		Reason:    req.Reason,
+		Status:    ReturnStatusRequested,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	returns[returnID] = ret
+
+	writeReturnResponse(w, order, ret, "Return opened successfully")
+
+	fmt.Printf("Return opened: %s for order %s, refund %s\n", returnID, order.ID, amount)
+}
+
+func completeReturnHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReturnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ret, exists := returns[req.ReturnID]
+	if !exists || ret.OrderID != req.OrderID {
+		http.Error(w, "Return not found", http.StatusNotFound)
+		return
+	}
+	order := orders[ret.OrderID]
+	if ret.Status == ReturnStatusCancelled {
+		writeReturnError(w, http.StatusConflict, fmt.Errorf("Return %s has been cancelled", ret.ID))
+		return
+	}
+
+	if ret.Status == ReturnStatusRequested {
+		remaining := make(map[string]int)
+		for _, item := range order.Items {
+			remaining[item.ID] += item.Quantity
+		}
+		for _, other := range orderReturns(order.ID, ReturnStatusCompleted) {
+			for _, item := range other.Items {
+				remaining[item.ID] -= item.Quantity
+			}
+		}
+		for _, item := range ret.Items {
+			remaining[item.ID] -= item.Quantity
+		}
+
+		status := OrderStatusReturned
+		for _, quantity := range remaining {
+			if quantity > 0 {
+				status = OrderStatusPartiallyReturned
+				break
+			}
+		}
+		if err := transitionOrder(&order, status, fmt.Sprintf("return %s received", ret.ID)); err != nil {
+			writeReturnError(w, http.StatusConflict, err)
+			return
+		}
+		orders[order.ID] = order
+
+		ret.Status = ReturnStatusCompleted
+		ret.UpdatedAt = time.Now()
+		returns[ret.ID] = ret
+	}
+
+	writeReturnResponse(w, order, ret, "Return completed successfully")
+
+	fmt.Printf("Return completed: %s for order %s, order is now %s\n", ret.ID, order.ID, order.Status)
+}
+
+func cancelReturnHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReturnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ret, exists := returns[req.ReturnID]
+	if !exists || ret.OrderID != req.OrderID {
+		http.Error(w, "Return not found", http.StatusNotFound)
+		return
+	}
+	if ret.Status == ReturnStatusCompleted {
+		writeReturnError(w, http.StatusConflict, fmt.Errorf("Return %s has already been completed", ret.ID))
+		return
+	}
+
+	if ret.Status == ReturnStatusRequested {
+		ret.Status = ReturnStatusCancelled
+		ret.UpdatedAt = time.Now()
+		returns[ret.ID] = ret
+	}
+
+	writeReturnResponse(w, orders[ret.OrderID], ret, "Return cancelled successfully")
+
+	fmt.Printf("Return cancelled: %s for order %s\n", ret.ID, ret.OrderID)
+}
+
+func orderReturns(orderID, status string) []Return {
+	var result []Return
+	for _, ret := range returns {
+		if ret.OrderID == orderID && (status == "" || ret.Status == status) {
+			result = append(result, ret)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+func writeReturnResponse(w http.ResponseWriter, order Order, ret Return, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReturnResponse{
+		Success:     true,
+		Message:     message,
+		ReturnID:    ret.ID,
+		OrderID:     order.ID,
+		CustomerID:  order.CustomerID,
+		Address:     &order.Address,
+		Status:      ret.Status,
+		OrderStatus: order.Status,
+		Amount:      &ret.Amount,
+		WeightGrams: itemsWeight(ret.Items),
+		Items:       ret.Items,
+	})
+}
+
+func writeReturnError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ReturnResponse{
+		Success: false,
+		Message: err.Error(),
+	})
+}
+
+func orderDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orderID := strings.TrimPrefix(r.URL.Path, "/orders/")
+	if orderID == "" || strings.Contains(orderID, "/") {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	mu.Lock()
+	order, exists := orders[orderID]
+	returnList := orderReturns(orderID, "")
+	mu.Unlock()
+	if !exists {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OrderDetailResponse{
+		Success: true,
+		Order:   order,
+		Returns: returnList,
+	})
+}
+
+func listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	customerID := query.Get("customer_id")
+
+	statuses := make(map[string]bool)
+	if filter := query.Get("status"); filter != "" {
+		for _, status := range strings.Split(filter, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !isOrderStatus(status) {
+				http.Error(w, fmt.Sprintf("Unknown order status %q", status), http.StatusBadRequest)
+				return
+			}
+			statuses[status] = true
+		}
+	}
+
+	page, err := pageParam(query.Get("page"), 1)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid page: %v", err), http.StatusBadRequest)
+		return
+	}
+	pageSize, err := pageParam(query.Get("page_size"), DefaultPageSize)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid page size: %v", err), http.StatusBadRequest)
+		return
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	mu.Lock()
+	matched := make([]Order, 0)
+	for _, order := range orders {
+		if customerID != "" && order.CustomerID != customerID {
+			continue
+		}
+		if len(statuses) > 0 && !statuses[order.Status] {
+			continue
+		}
+		matched = append(matched, order)
+	}
+	mu.Unlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	total := len(matched)
+	start := (page - 1) * pageSize
+	if start > total {
+		start = total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OrderListResponse{
+		Success:    true,
+		Orders:     matched[start:end],
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      total,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	})
+}
+
+func pageParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, errors.New("must be at least 1")
+	}
+	return n, nil
+}
+
+func reserveCouponHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CouponRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	order, exists := orders[req.OrderID]
+	if !exists {
+		mu.Unlock()
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if order.CouponCode == "" {
+		mu.Unlock()
+		http.Error(w, "Order has no coupon", http.StatusBadRequest)
+		return
+	}
+
+	reservation, reserved := couponReservations[req.OrderID]
+	if !reserved || reservation.Status != CouponStatusReserved {
+		if err := checkCouponAvailable(order.CouponCode); err != nil {
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(CouponResponse{
+				Success: false,
+				Message: err.Error(),
+				OrderID: req.OrderID,
+				Code:    order.CouponCode,
+			})
+			return
+		}
+
+		couponUsage[order.CouponCode]++
+		reservation = CouponReservation{
+			OrderID:    req.OrderID,
+			Code:       order.CouponCode,
+			Status:     CouponStatusReserved,
+			ReservedAt: time.Now(),
+		}
+		couponReservations[req.OrderID] = reservation
+	}
+	mu.Unlock()
+
+	resp := CouponResponse{
+		Success: true,
+		Message: "Coupon reserved successfully",
+		OrderID: req.OrderID,
+		Code:    reservation.Code,
+		Status:  reservation.Status,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Coupon reserved: %s for order %s\n", reservation.Code, req.OrderID)
+}
+
+func releaseCouponHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CouponRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	reservation, reserved := couponReservations[req.OrderID]
+	if !reserved {
+		mu.Unlock()
+		http.Error(w, "Coupon reservation not found", http.StatusNotFound)
+		return
+	}
+	if reservation.Status == CouponStatusRedeemed {
+		mu.Unlock()
+		http.Error(w, "Coupon has already been redeemed", http.StatusConflict)
+		return
+	}
+	if reservation.Status == CouponStatusReserved {
+		couponUsage[reservation.Code]--
+		reservation.Status = CouponStatusReleased
+		couponReservations[req.OrderID] = reservation
+	}
+	mu.Unlock()
+
+	resp := CouponResponse{
+		Success: true,
+		Message: "Coupon released successfully",
+		OrderID: req.OrderID,
+		Code:    reservation.Code,
+		Status:  reservation.Status,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Coupon released: %s for order %s\n", reservation.Code, req.OrderID)
+}
+
+func productsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	products := make([]Product, 0, len(catalog))
+	for _, product := range catalog {
+		products = append(products, product)
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ID < products[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProductListResponse{
+		Success:  true,
+		Products: products,
+	})
+}
+
+func loadCatalog(path string) (map[string]Product, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var products []Product
+	if err := json.Unmarshal(data, &products); err != nil {
+		return nil, err
+	}
+
+	catalog := make(map[string]Product, len(products))
+	for _, product := range products {
+		if product.ID == "" {
+			return nil, errors.New("product without ID")
+		}
+		if _, exists := catalog[product.ID]; exists {
+			return nil, fmt.Errorf("duplicate product %s", product.ID)
+		}
+		for currency, price := range product.Prices {
+			if err := (Money{Currency: currency}).Validate(); err != nil {
+				return nil, fmt.Errorf("product %s: %w", product.ID, err)
+			}
+			if price <= 0 {
+				return nil, fmt.Errorf("product %s: price in %s must be greater than zero", product.ID, currency)
+			}
+		}
+		if product.WeightGrams < 0 {
+			return nil, fmt.Errorf("product %s: weight must not be negative", product.ID)
+		}
+		if product.TaxCategory == "" {
+			product.TaxCategory = DefaultTaxCategory
+		}
+		catalog[product.ID] = product
+	}
+	return catalog, nil
+}
+
+func priceOrder(requested []ItemRequest, currency, couponCode string, address Address) (OrderPricing, error) {
+	items, subtotal, err := priceItems(requested, currency)
+	if err != nil {
+		return OrderPricing{}, err
+	}
+	discounts, err := applyPromotions(items, subtotal, couponCode)
+	if err != nil {
+		return OrderPricing{}, err
+	}
+	region, err := taxRegion(address)
+	if err != nil {
+		return OrderPricing{}, err
+	}
+	taxes, err := calculateTax(items, subtotal, discounts, region)
+	if err != nil {
+		return OrderPricing{}, err
+	}
+
+	amount := subtotal
+	for _, discount := range discounts {
+		amount, _ = amount.Sub(discount.Amount)
+	}
+	taxTotal := Money{Currency: currency}
+	for _, tax := range taxes {
+		taxTotal, _ = taxTotal.Add(tax.Amount)
+	}
+	amount, _ = amount.Add(taxTotal)
+
+	return OrderPricing{
+		Items:       items,
+		Subtotal:    subtotal,
+		Discounts:   discounts,
+		Taxes:       taxes,
+		TaxTotal:    taxTotal,
+		Amount:      amount,
+		WeightGrams: itemsWeight(items),
+	}, nil
+}
+
+func pricingOf(order Order) OrderPricing {
+	return OrderPricing{
+		Items:       order.Items,
+		Subtotal:    order.Subtotal,
+		Discounts:   order.Discounts,
+		Taxes:       order.Taxes,
+		TaxTotal:    order.TaxTotal,
+		Amount:      order.Amount,
+		WeightGrams: order.WeightGrams,
+	}
+}
+
+func itemsWeight(items []Item) int {
+	weight := 0
+	for _, item := range items {
+		weight += catalog[item.ID].WeightGrams * item.Quantity
+	}
+	return weight
+}
+
+func priceItems(requested []ItemRequest, currency string) ([]Item, Money, error) {
+	total := Money{Currency: currency}
+	items := make([]Item, 0, len(requested))
+	for _, item := range requested {
+		if item.Quantity <= 0 {
+			return nil, Money{}, fmt.Errorf("Quantity for item %s must be greater than zero", item.ID)
+		}
+		product, ok := catalog[item.ID]
+		if !ok {
+			return nil, Money{}, fmt.Errorf("Unknown product %q", item.ID)
+		}
+		minorUnits, ok := product.Prices[currency]
+		if !ok {
+			return nil, Money{}, fmt.Errorf("Product %s is not sold in %s", item.ID, currency)
+		}
+
+		price := Money{MinorUnits: minorUnits, Currency: currency}
+		if item.Price != nil && *item.Price != price {
+			return nil, Money{}, fmt.Errorf("Price for item %s is %s, not %s", item.ID, price, item.Price)
+		}
+
+		items = append(items, Item{
+			ID:          product.ID,
+			Name:        product.Name,
+			Price:       price,
+			Quantity:    item.Quantity,
+			WeightGrams: product.WeightGrams,
+		})
+		total, _ = total.Add(price.Mul(int64(item.Quantity)))
+	}
+	return items, total, nil
+}
+
+func itemRequests(items []Item) []ItemRequest {
+	requests := make([]ItemRequest, 0, len(items))
+	for _, item := range items {
+		requests = append(requests, ItemRequest{ID: item.ID, Quantity: item.Quantity})
+	}
+	return requests
+}
+
+func loadPromotions(path string) ([]Promotion, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var promos []Promotion
+	if err := json.Unmarshal(data, &promos); err != nil {
+		return nil, err
+	}
+
+	codes := make(map[string]bool)
+	for i, promo := range promos {
+		switch promo.Type {
+		case PromotionTypePercentage:
+			if promo.Percent <= 0 || promo.Percent > 100 {
+				return nil, fmt.Errorf("promotion %s: percent must be between 1 and 100", promo.ID)
+			}
+		case PromotionTypeFixed:
+			if len(promo.AmountOff) == 0 {
+				return nil, fmt.Errorf("promotion %s: amount_off is required", promo.ID)
+			}
+		case PromotionTypeBuyXGetY:
+			if _, ok := catalog[promo.ProductID]; !ok {
+				return nil, fmt.Errorf("promotion %s: unknown product %q", promo.ID, promo.ProductID)
+			}
+			if promo.BuyQuantity <= 0 || promo.FreeQuantity <= 0 {
+				return nil, fmt.Errorf("promotion %s: buy_quantity and free_quantity must be greater than zero", promo.ID)
+			}
+		default:
+			return nil, fmt.Errorf("promotion %s: unknown type %q", promo.ID, promo.Type)
+		}
+
+		if promo.Code != "" {
+			promos[i].Code = normalizeCouponCode(promo.Code)
+			if codes[promos[i].Code] {
+				return nil, fmt.Errorf("duplicate coupon code %s", promos[i].Code)
+			}
+			codes[promos[i].Code] = true
+		}
+	}
+	return promos, nil
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func findCoupon(code string) (Promotion, bool) {
+	for _, promo := range promotions {
+		if promo.Code != "" && promo.Code == code {
+			return promo, true
+		}
+	}
+	return Promotion{}, false
+}
+
+func checkCouponAvailable(code string) error {
+	promo, ok := findCoupon(code)
+	if !ok {
+		return fmt.Errorf("Unknown coupon code %q", code)
+	}
+	if promo.MaxRedemptions > 0 && couponUsage[code] >= promo.MaxRedemptions {
+		return fmt.Errorf("Coupon %s has been fully redeemed", code)
+	}
+	return nil
+}
+
+var promotionOrder = map[string]int{
+	PromotionTypeBuyXGetY:   0,
+	PromotionTypePercentage: 1,
+	PromotionTypeFixed:      2,
+}
+
+func applyPromotions(items []Item, subtotal Money, couponCode string) ([]DiscountLine, error) {
+	var applicable []Promotion
+	for _, promo := range promotions {
+		if promo.Code == "" {
+			applicable = append(applicable, promo)
+		}
+	}
+	if couponCode != "" {
+		coupon, ok := findCoupon(couponCode)
+		if !ok {
+			return nil, fmt.Errorf("Unknown coupon code %q", couponCode)
+		}
+		applicable = append(applicable, coupon)
+	}
+	sort.SliceStable(applicable, func(i, j int) bool {
+		return promotionOrder[applicable[i].Type] < promotionOrder[applicable[j].Type]
+	})
+
+	remaining := subtotal
+	var discounts []DiscountLine
+	for _, promo := range applicable {
+		discount, err := promotionDiscount(promo, items, subtotal, remaining)
+		if err != nil {
+			if promo.Code != "" {
+				return nil, fmt.Errorf("Coupon %s cannot be applied: %v", promo.Code, err)
+			}
+			continue
+		}
+		if discount.MinorUnits > remaining.MinorUnits {
+			discount = remaining
+		}
+		if !discount.IsPositive() {
+			continue
+		}
+
+		remaining, _ = remaining.Sub(discount)
+		line := DiscountLine{
+			PromotionID: promo.ID,
+			Code:        promo.Code,
+			Description: promo.Description,
+			Amount:      discount,
+		}
+		if promo.Type == PromotionTypeBuyXGetY {
+			line.ProductID = promo.ProductID
+		}
+		discounts = append(discounts, line)
+	}
+	return discounts, nil
+}
+
+func promotionDiscount(promo Promotion, items []Item, subtotal, remaining Money) (Money, error) {
+	currency := subtotal.Currency
+	if promo.MinBasket != nil {
+		minimum, ok := promo.MinBasket[currency]
+		if !ok {
+			return Money{}, fmt.Errorf("not available in %s", currency)
+		}
+		if subtotal.MinorUnits < minimum {
+			return Money{}, fmt.Errorf("requires a minimum basket of %s", Money{MinorUnits: minimum, Currency: currency})
+		}
+	}
+
+	switch promo.Type {
+	case PromotionTypePercentage:
+		return remaining.MulRat(big.NewRat(promo.Percent, 100)), nil
+	case PromotionTypeFixed:
+		amountOff, ok := promo.AmountOff[currency]
+		if !ok {
+			return Money{}, fmt.Errorf("not available in %s", currency)
+		}
+		return Money{MinorUnits: amountOff, Currency: currency}, nil
+	case PromotionTypeBuyXGetY:
+		discount := Money{Currency: currency}
+		for _, item := range items {
+			if item.ID != promo.ProductID {
+				continue
+			}
+			free := item.Quantity / (promo.BuyQuantity + promo.FreeQuantity) * promo.FreeQuantity
+			discount, _ = discount.Add(item.Price.Mul(int64(free)))
+		}
+		if !discount.IsPositive() {
+			return Money{}, fmt.Errorf("requires %d of product %s", promo.BuyQuantity+promo.FreeQuantity, promo.ProductID)
+		}
+		return discount, nil
+	}
+	return Money{}, fmt.Errorf("unknown promotion type %q", promo.Type)
+}
+
+func loadTaxRates(path string) (map[string]map[string]TaxRate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TaxRate
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	rates := make(map[string]map[string]TaxRate)
+	for _, entry := range entries {
+		entry.Region = strings.ToUpper(strings.TrimSpace(entry.Region))
+		value, ok := new(big.Rat).SetString(entry.Rate)
+		if !ok || value.Sign() < 0 {
+			return nil, fmt.Errorf("invalid rate %q for %s/%s", entry.Rate, entry.Region, entry.Category)
+		}
+		if rates[entry.Region] == nil {
+			rates[entry.Region] = make(map[string]TaxRate)
+		}
+		if _, exists := rates[entry.Region][entry.Category]; exists {
+			return nil, fmt.Errorf("duplicate rate for %s/%s", entry.Region, entry.Category)
+		}
+		rates[entry.Region][entry.Category] = entry
+	}
+	return rates, nil
+}
+
+func taxRegion(address Address) (string, error) {
+	country := strings.ToUpper(strings.TrimSpace(address.Country))
+	region := strings.ToUpper(strings.Join(strings.Fields(address.Region), " "))
+	if region != "" {
+		if _, ok := taxRates[country+"-"+region]; ok {
+			return country + "-" + region, nil
+		}
+	}
+	if _, ok := taxRates[country]; ok {
+		return country, nil
+	}
+	if region != "" {
+		return "", fmt.Errorf("No tax rates for destination %s-%s", country, region)
+	}
+	return "", fmt.Errorf("No tax rates for destination %s", country)
+}
+
+func calculateTax(items []Item, subtotal Money, discounts []DiscountLine, region string) ([]TaxLine, error) {
+	rates, ok := taxRates[region]
+	if !ok {
+		return nil, fmt.Errorf("No tax rates for destination %s", region)
+	}
+
+	var categories []string
+	bases := make(map[string]Money)
+	for _, item := range items {
+		category := catalog[item.ID].TaxCategory
+		base, seen := bases[category]
+		if !seen {
+			categories = append(categories, category)
+			base = Money{Currency: subtotal.Currency}
+		}
+		bases[category], _ = base.Add(item.Price.Mul(int64(item.Quantity)))
+	}
+
+	net := subtotal
+	orderDiscount := Money{Currency: subtotal.Currency}
+	for _, discount := range discounts {
+		if discount.ProductID == "" {
+			orderDiscount, _ = orderDiscount.Add(discount.Amount)
+			continue
+		}
+		category := catalog[discount.ProductID].TaxCategory
+		bases[category], _ = bases[category].Sub(discount.Amount)
+		net, _ = net.Sub(discount.Amount)
+	}
+
+	allocated := Money{Currency: subtotal.Currency}
+	lines := make([]TaxLine, 0, len(categories))
+	for i, category := range categories {
+		rate, ok := rates[category]
+		if !ok {
+			return nil, fmt.Errorf("No tax rate for category %s in %s", category, region)
+		}
+
+		share := Money{Currency: subtotal.Currency}
+		if net.IsPositive() {
+			share = orderDiscount.MulRat(big.NewRat(bases[category].MinorUnits, net.MinorUnits))
+		}
+		if i == len(categories)-1 {
+			share, _ = orderDiscount.Sub(allocated)
+		}
+		allocated, _ = allocated.Add(share)
+		taxable, _ := bases[category].Sub(share)
+
+		value, _ := new(big.Rat).SetString(rate.Rate)
+		lines = append(lines, TaxLine{
+			Region:   region,
+			Category: category,
+			Rate:     rate.Rate,
+			Taxable:  taxable,
+			Amount:   taxable.MulRat(value),
+		})
+	}
+	return lines, nil
+}
+
+func transitionOrder(order *Order, to, reason string) error {
+	allowed := false
+	for _, next := range orderTransitions[order.Status] {
+		if next == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s to %s", ErrIllegalTransition, order.Status, to)
+	}
+
+	order.History = append(order.History, StatusChange{
+		From:   order.Status,
+		To:     to,
+		Reason: reason,
+		At:     time.Now(),
+	})
+	order.Status = to
+
+	if to == OrderStatusCompleted {
+		if reservation, reserved := couponReservations[order.ID]; reserved && reservation.Status == CouponStatusReserved {
+			reservation.Status = CouponStatusRedeemed
+			couponReservations[order.ID] = reservation
+		}
+	}
+	return nil
+}
+
+func isOrderStatus(status string) bool {
+	switch status {
+	case OrderStatusPending, OrderStatusAwaitingPayment, OrderStatusPaid, OrderStatusShipping,
+		OrderStatusCompleted, OrderStatusCancelled, OrderStatusRefunded, OrderStatusPartiallyReturned, OrderStatusReturned:
+		return true
+	}
+	return false
+}
+
+func writeTransitionError(w http.ResponseWriter, orderID, status string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(OrderResponse{
+		Success: false,
+		Message: err.Error(),
+		OrderID: orderID,
+		Status:  status,
+	})
+}
+
+func completeOrder(orderID string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	order, exists := orders[orderID]
+	if !exists {
+		return false
+	}
+
+	if err := transitionOrder(&order, OrderStatusCompleted, ""); err != nil {
+		return false
+	}
 	orders[orderID] = order
 	fmt.Printf("Order completed: %s\n", orderID)
 	return true
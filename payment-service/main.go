@@ -1,49 +1,273 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/money"
+)
+
+const (
+	PaymentGatewayURL  = "http://localhost:8090"
+	PaymentCallbackURL = "http://localhost:8082/payment-callback"
+	GatewayTimeout     = 5 * time.Second
+	SettlementCurrency = "USD"
+	FXRatesFile        = "fx-rates.json"
+)
+
+const (
+	PaymentMethodWallet = "WALLET"
+	PaymentMethodCard   = "CARD"
+)
+
+const (
+	PaymentStatusPending           = "PENDING"
+	PaymentStatusSuccess           = "SUCCESS"
+	PaymentStatusFailed            = "FAILED"
+	PaymentStatusRefunded          = "REFUNDED"
+	PaymentStatusPartiallyRefunded = "PARTIALLY_REFUNDED"
+	PaymentStatusCancelled         = "CANCELLED"
+)
+
+const (
+	HoldStatusActive   = "ACTIVE"
+	HoldStatusCaptured = "CAPTURED"
+	HoldStatusReleased = "RELEASED"
+)
+
+const (
+	FraudDecisionApprove = "APPROVE"
+	FraudDecisionReview  = "REVIEW"
+	FraudDecisionReject  = "REJECT"
 )
 
 const (
-	PaymentStatusSuccess  = "SUCCESS"
-	PaymentStatusFailed   = "FAILED"
-	PaymentStatusRefunded = "REFUNDED"
+	FraudReviewThreshold     = 40
+	FraudRejectThreshold     = 70
+	FraudVelocityWindow      = 10 * time.Minute
+	FraudVelocityLimit       = 3
+	FraudAmountAnomalyFactor = 5
+	FraudMinHistory          = 3
 )
 
+const (
+	BlocklistTypeCustomer = "customer"
+	BlocklistTypeAddress  = "address"
+)
+
+const (
+	GatewayStatusPending    = "PENDING"
+	GatewayStatusAuthorized = "AUTHORIZED"
+	GatewayStatusCaptured   = "CAPTURED"
+	GatewayStatusRefunded   = "REFUNDED"
+	GatewayStatusVoided     = "VOIDED"
+	GatewayStatusDeclined   = "DECLINED"
+)
+
+type Money = money.Money
+
 type Payment struct {
-	ID      string  `json:"id"`
-	OrderID string  `json:"order_id"`
-	Amount  float64 `json:"amount"`
-	Status  string  `json:"status"`
+	ID               string `json:"id"`
+	OrderID          string `json:"order_id"`
+	CustomerID       string `json:"customer_id"`
+	Amount           Money  `json:"amount"`
+	RefundedAmount   Money  `json:"refunded_amount"`
+	SettlementAmount Money  `json:"settlement_amount"`
+	SettledRefunds   Money  `json:"settled_refunds"`
+	FXRate           FXRate `json:"fx_rate"`
+	Method           string `json:"method"`
+	Status           string `json:"status"`
+	GatewayReference string `json:"gateway_reference,omitempty"`
+	FailureReason    string `json:"failure_reason,omitempty"`
+}
+
+type FXRate struct {
+	Base          string    `json:"base"`
+	Quote         string    `json:"quote"`
+	Rate          string    `json:"rate"`
+	EffectiveFrom time.Time `json:"effective_from"`
+}
+
+type Wallet struct {
+	CustomerID string    `json:"customer_id"`
+	Balance    Money     `json:"balance"`
+	Held       Money     `json:"held"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type Hold struct {
+	ID         string    `json:"id"`
+	CustomerID string    `json:"customer_id"`
+	OrderID    string    `json:"order_id"`
+	Amount     Money     `json:"amount"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 type ProcessPaymentRequest struct {
-	OrderID string  `json:"order_id"`
-	Amount  float64 `json:"amount"`
+	OrderID    string `json:"order_id"`
+	CustomerID string `json:"customer_id"`
+	Amount     Money  `json:"amount"`
+	Method     string `json:"method"`
 }
 
-type PaymentResponse struct {
-	Success   bool   `json:"success"`
-	Message   string `json:"message"`
+type RefundPaymentRequest struct {
+	OrderID   string `json:"order_id"`
 	PaymentID string `json:"payment_id,omitempty"`
-	OrderID   string `json:"order_id,omitempty"`
-	Status    string `json:"status,omitempty"`
+	Amount    *Money `json:"amount,omitempty"`
+}
+
+type GatewayRequest struct {
+	TransactionID string `json:"transaction_id,omitempty"`
+	Reference     string `json:"reference,omitempty"`
+	Amount        *Money `json:"amount,omitempty"`
+	CallbackURL   string `json:"callback_url,omitempty"`
+}
+
+type GatewayResult struct {
+	TransactionID  string `json:"transaction_id"`
+	Reference      string `json:"reference"`
+	Status         string `json:"status"`
+	Amount         Money  `json:"amount"`
+	RefundedAmount Money  `json:"refunded_amount"`
+	Message        string `json:"message"`
+	Approved       bool   `json:"-"`
+}
+
+type PaymentGateway interface {
+	Authorize(reference string, amount Money) (GatewayResult, error)
+	Capture(transactionID string, amount Money) (GatewayResult, error)
+	Refund(transactionID string, amount Money) (GatewayResult, error)
+	Void(transactionID string) (GatewayResult, error)
+	Query(reference string) (GatewayResult, error)
+}
+
+type httpPaymentGateway struct {
+	baseURL     string
+	callbackURL string
+	client      *http.Client
+}
+
+type FraudCheckRequest struct {
+	OrderID         string `json:"order_id"`
+	CustomerID      string `json:"customer_id"`
+	Amount          Money  `json:"amount"`
+	ShippingAddress string `json:"shipping_address"`
+	BillingAddress  string `json:"billing_address,omitempty"`
+}
+
+type FraudCheck struct {
+	ID         string    `json:"id"`
+	OrderID    string    `json:"order_id"`
+	CustomerID string    `json:"customer_id"`
+	Amount     Money     `json:"amount"`
+	Score      int       `json:"score"`
+	Decision   string    `json:"decision"`
+	Reasons    []string  `json:"reasons"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+type FraudCheckResponse struct {
+	Success  bool     `json:"success"`
+	Message  string   `json:"message"`
+	CheckID  string   `json:"check_id,omitempty"`
+	OrderID  string   `json:"order_id,omitempty"`
+	Score    int      `json:"score"`
+	Decision string   `json:"decision,omitempty"`
+	Reasons  []string `json:"reasons,omitempty"`
+}
+
+type BlocklistEntry struct {
+	Type    string    `json:"type"`
+	Value   string    `json:"value"`
+	Reason  string    `json:"reason,omitempty"`
+	AddedAt time.Time `json:"added_at"`
 }
 
+type WalletRequest struct {
+	CustomerID string `json:"customer_id"`
+	OrderID    string `json:"order_id,omitempty"`
+	Amount     Money  `json:"amount"`
+}
+
+type WalletResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	CustomerID string `json:"customer_id,omitempty"`
+	Balance    Money  `json:"balance"`
+	Held       Money  `json:"held"`
+	Available  Money  `json:"available"`
+	HoldID     string `json:"hold_id,omitempty"`
+}
+
+type PaymentResponse struct {
+	Success          bool    `json:"success"`
+	Message          string  `json:"message"`
+	PaymentID        string  `json:"payment_id,omitempty"`
+	OrderID          string  `json:"order_id,omitempty"`
+	Status           string  `json:"status,omitempty"`
+	RefundedAmount   *Money  `json:"refunded_amount,omitempty"`
+	SettlementAmount *Money  `json:"settlement_amount,omitempty"`
+	FXRate           *FXRate `json:"fx_rate,omitempty"`
+}
+
+type FXRateListResponse struct {
+	Success            bool     `json:"success"`
+	SettlementCurrency string   `json:"settlement_currency"`
+	Rates              []FXRate `json:"rates"`
+}
+
+type PaymentListResponse struct {
+	Success  bool      `json:"success"`
+	Payments []Payment `json:"payments"`
+}
+
+var fraudHighAmount = Money{MinorUnits: 500000, Currency: SettlementCurrency}
+
 var (
-	payments = make(map[string]Payment)
-	mu       sync.Mutex
-	nextID   = 1
+	payments   = make(map[string]Payment)
+	wallets    = make(map[string]Wallet)
+	holds      = make(map[string]Hold)
+	mu         sync.Mutex
+	nextID     = 1
+	nextHoldID = 1
+
+	fxRates []FXRate
+
+	fraudChecks      = make(map[string]FraudCheck)
+	blocklist        = make(map[string]BlocklistEntry)
+	nextFraudCheckID = 1
+
+	gateway PaymentGateway = newHTTPPaymentGateway(PaymentGatewayURL, PaymentCallbackURL, GatewayTimeout)
 )
 
 func main() {
+	rates, err := loadFXRates(FXRatesFile)
+	if err != nil {
+		log.Fatalf("Failed to load FX rates from %s: %v", FXRatesFile, err)
+	}
+	fxRates = rates
+
 	http.HandleFunc("/process-payment", processPaymentHandler)
 	http.HandleFunc("/refund-payment", refundPaymentHandler)
 	http.HandleFunc("/payment-status", paymentStatusHandler)
+	http.HandleFunc("/payments", listPaymentsHandler)
+	http.HandleFunc("/payment-callback", paymentCallbackHandler)
+	http.HandleFunc("/top-up-wallet", topUpWalletHandler)
+	http.HandleFunc("/wallet-balance", walletBalanceHandler)
+	http.HandleFunc("/hold-funds", holdFundsHandler)
+	http.HandleFunc("/release-hold", releaseHoldHandler)
+	http.HandleFunc("/fraud-check", fraudCheckHandler)
+	http.HandleFunc("/fraud-blocklist", fraudBlocklistHandler)
+	http.HandleFunc("/fx-rates", fxRatesHandler)
 
 	fmt.Println("Payment Service started on :8082")
 	log.Fatal(http.ListenAndServe(":8082", nil))
@@ -65,50 +289,123 @@ func processPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Order ID is required", http.StatusBadRequest)
 		return
 	}
-	if req.Amount <= 0 {
+	if req.CustomerID == "" {
+		http.Error(w, "Customer ID is required", http.StatusBadRequest)
+		return
+	}
+	if err := req.Amount.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid amount: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !req.Amount.IsPositive() {
 		http.Error(w, "Amount must be greater than zero", http.StatusBadRequest)
 		return
 	}
 
+	if req.Method == "" {
+		req.Method = PaymentMethodWallet
+	}
+	if req.Method != PaymentMethodWallet && req.Method != PaymentMethodCard {
+		http.Error(w, "Unsupported payment method", http.StatusBadRequest)
+		return
+	}
+
+	settlementAmount, rate, err := convertToSettlement(req.Amount)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot settle payment: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	paymentSuccess := simulatePaymentProcessing(req.Amount)
+	failureReason := ""
+	if !paymentSuccess {
+		failureReason = "Payment processing failed"
+	}
 
 	mu.Lock()
 	paymentID := fmt.Sprintf("PAY-%d", nextID)
 	nextID++
 
+	if paymentSuccess && req.Method == PaymentMethodWallet {
+		if err := debitWallet(req.CustomerID, req.OrderID, settlementAmount); err != nil {
+			paymentSuccess = false
+			failureReason = err.Error()
+		}
+	}
+	payments[paymentID] = Payment{
+		ID:               paymentID,
+		OrderID:          req.OrderID,
+		CustomerID:       req.CustomerID,
+		Amount:           req.Amount,
+		RefundedAmount:   Money{Currency: req.Amount.Currency},
+		SettlementAmount: settlementAmount,
+		SettledRefunds:   Money{Currency: settlementAmount.Currency},
+		FXRate:           rate,
+		Method:           req.Method,
+		Status:           PaymentStatusPending,
+	}
+	mu.Unlock()
+
+	gatewayReference := ""
+	pending := false
+	if paymentSuccess && req.Method == PaymentMethodCard {
+		reference, authPending, err := chargeCard(paymentID, settlementAmount)
+		if err != nil {
+			paymentSuccess = false
+			failureReason = err.Error()
+		}
+		gatewayReference = reference
+		pending = authPending
+	}
+
 	status := PaymentStatusSuccess
 	if !paymentSuccess {
 		status = PaymentStatusFailed
+	} else if pending {
+		status = PaymentStatusPending
 	}
 
-	payment := Payment{
-		ID:      paymentID,
-		OrderID: req.OrderID,
-		Amount:  req.Amount,
-		Status:  status,
+	mu.Lock()
+	payment := payments[paymentID]
+	if payment.Status == PaymentStatusPending {
+		payment.Status = status
+		payment.FailureReason = failureReason
+	} else {
+		status = payment.Status
+		failureReason = payment.FailureReason
+		paymentSuccess = status == PaymentStatusSuccess
+		pending = false
+	}
+	if payment.GatewayReference == "" {
+		payment.GatewayReference = gatewayReference
 	}
 	payments[paymentID] = payment
 	mu.Unlock()
 
 	resp := PaymentResponse{
-		Success:   paymentSuccess,
-		PaymentID: paymentID,
-		OrderID:   req.OrderID,
-		Status:    status,
+		Success:          paymentSuccess,
+		PaymentID:        paymentID,
+		OrderID:          req.OrderID,
+		Status:           status,
+		SettlementAmount: &settlementAmount,
+		FXRate:           &rate,
 	}
 
-	if paymentSuccess {
+	if pending {
+		resp.Message = "Payment pending confirmation from gateway"
+		w.WriteHeader(http.StatusAccepted)
+	} else if paymentSuccess {
 		resp.Message = "Payment processed successfully"
 		w.WriteHeader(http.StatusOK)
 	} else {
-		resp.Message = "Payment processing failed"
+		resp.Message = failureReason
 		w.WriteHeader(http.StatusBadRequest)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 
-	fmt.Printf("Payment processed: %s for order %s with status %s\n", paymentID, req.OrderID, status)
+	fmt.Printf("Payment processed: %s for order %s with status %s (%s settled as %s at %s)\n", paymentID, req.OrderID, status, req.Amount, settlementAmount, rate.Rate)
 }
 
 func refundPaymentHandler(w http.ResponseWriter, r *http.Request) {
@@ -117,50 +414,154 @@ func refundPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		OrderID string `json:"order_id"`
-	}
+	var req RefundPaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	if req.PaymentID == "" {
+		http.Error(w, "Payment ID is required", http.StatusBadRequest)
+		return
+	}
+
 	mu.Lock()
-	var paymentID string
-	var payment Payment
-	var found bool
+	paymentID := req.PaymentID
+	payment, found := payments[paymentID]
+	if !found || payment.OrderID != req.OrderID || (payment.Status != PaymentStatusSuccess && payment.Status != PaymentStatusPartiallyRefunded && payment.Status != PaymentStatusPending) {
+		mu.Unlock()
+		http.Error(w, "No successful payment found for the order", http.StatusNotFound)
+		return
+	}
+
+	if payment.Status == PaymentStatusPending {
+		mu.Unlock()
+		if payment.GatewayReference == "" {
+			http.Error(w, fmt.Sprintf("Payment %s is still being authorized by the gateway, retry later", paymentID), http.StatusConflict)
+			return
+		}
 
-	for id, p := range payments {
-		if p.OrderID == req.OrderID && p.Status == PaymentStatusSuccess {
-			paymentID = id
-			payment = p
-			found = true
-			break
+		result, err := gateway.Void(payment.GatewayReference)
+		if err != nil {
+			if current, queryErr := queryGatewayTransaction(payment); queryErr == nil && current.Status == GatewayStatusVoided {
+				fmt.Printf("Void of %s timed out but was applied by the gateway\n", payment.GatewayReference)
+				result, err = current, nil
+				result.Approved = true
+			}
 		}
+		if err != nil || !result.Approved {
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Payment gateway error: %v", err), http.StatusBadGateway)
+			} else {
+				http.Error(w, fmt.Sprintf("Void rejected by gateway: %s", result.Message), http.StatusBadGateway)
+			}
+			return
+		}
+
+		mu.Lock()
+		current := payments[paymentID]
+		if current.Status == PaymentStatusPending {
+			current.Status = PaymentStatusCancelled
+			current.FailureReason = "Pending payment cancelled before confirmation"
+			payments[paymentID] = current
+		}
+		mu.Unlock()
+
+		resp := PaymentResponse{
+			Success:   true,
+			Message:   "Pending payment cancelled successfully",
+			PaymentID: paymentID,
+			OrderID:   req.OrderID,
+			Status:    current.Status,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+
+		fmt.Printf("Pending payment cancelled: %s for order %s\n", paymentID, req.OrderID)
+		return
 	}
 
-	if !found {
+	remaining, _ := payment.Amount.Sub(payment.RefundedAmount)
+	amount := remaining
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+	if cmp, err := amount.Cmp(remaining); err != nil {
 		mu.Unlock()
-		http.Error(w, "No successful payment found for the order", http.StatusNotFound)
+		http.Error(w, fmt.Sprintf("Invalid refund amount: %v", err), http.StatusBadRequest)
+		return
+	} else if cmp > 0 || !amount.IsPositive() {
+		mu.Unlock()
+		http.Error(w, fmt.Sprintf("Refund amount must be between 0 and %s", remaining), http.StatusBadRequest)
 		return
 	}
 
-	payment.Status = PaymentStatusRefunded
+	settlementRemaining, _ := payment.SettlementAmount.Sub(payment.SettledRefunds)
+	settlementRefund := settlementRemaining
+	if amount != remaining {
+		converted, err := applyFXRate(amount, payment.SettlementAmount.Currency, payment.FXRate)
+		if err != nil {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Cannot settle refund: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if converted.MinorUnits < settlementRemaining.MinorUnits {
+			settlementRefund = converted
+		}
+	}
+
+	payment.RefundedAmount, _ = payment.RefundedAmount.Add(amount)
+	payment.SettledRefunds, _ = payment.SettledRefunds.Add(settlementRefund)
+	payment.Status = refundedStatus(payment)
 	payments[paymentID] = payment
 	mu.Unlock()
 
+	if payment.Method == PaymentMethodCard {
+		result, err := gateway.Refund(payment.GatewayReference, settlementRefund)
+		if err != nil {
+			if applied, queryErr := refundApplied(payment); queryErr != nil {
+				err = fmt.Errorf("%v (refund state unknown: %v)", err, queryErr)
+			} else if applied {
+				fmt.Printf("Refund of %s timed out but was applied by the gateway\n", payment.GatewayReference)
+				result, err = GatewayResult{Approved: true}, nil
+			}
+		}
+		if err != nil || !result.Approved {
+			mu.Lock()
+			current := payments[paymentID]
+			current.RefundedAmount, _ = current.RefundedAmount.Sub(amount)
+			current.SettledRefunds, _ = current.SettledRefunds.Sub(settlementRefund)
+			current.Status = refundedStatus(current)
+			payments[paymentID] = current
+			mu.Unlock()
+
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Payment gateway error: %v", err), http.StatusBadGateway)
+			} else {
+				http.Error(w, fmt.Sprintf("Refund rejected by gateway: %s", result.Message), http.StatusBadGateway)
+			}
+			return
+		}
+	} else {
+		mu.Lock()
+		creditWallet(payment.CustomerID, settlementRefund)
+		mu.Unlock()
+	}
+
 	resp := PaymentResponse{
-		Success:   true,
-		Message:   "Payment refunded successfully",
-		PaymentID: paymentID,
-		OrderID:   req.OrderID,
-		Status:    PaymentStatusRefunded,
+		Success:        true,
+		Message:        "Payment refunded successfully",
+		PaymentID:      paymentID,
+		OrderID:        req.OrderID,
+		Status:         payment.Status,
+		RefundedAmount: &payment.RefundedAmount,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 
-	fmt.Printf("Payment refunded: %s for order %s\n", paymentID, req.OrderID)
+	fmt.Printf("Payment refunded: %s for order %s with %s (settled %s at %s)\n", paymentID, req.OrderID, amount, settlementRefund, payment.FXRate.Rate)
 }
 
 func paymentStatusHandler(w http.ResponseWriter, r *http.Request) {
@@ -170,7 +571,8 @@ func paymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	orderID := r.URL.Query().Get("order_id")
-	if orderID == "" {
+	paymentID := r.URL.Query().Get("payment_id")
+	if orderID == "" && paymentID == "" {
 		http.Error(w, "Order ID is required", http.StatusBadRequest)
 		return
 	}
@@ -179,11 +581,15 @@ func paymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 	var payment Payment
 	var found bool
 
-	for _, p := range payments {
-		if p.OrderID == orderID {
-			payment = p
-			found = true
-			break
+	if paymentID != "" {
+		payment, found = payments[paymentID]
+	} else {
+		for _, p := range payments {
+			if p.OrderID == orderID {
+				payment = p
+				found = true
+				break
+			}
 		}
 	}
 	mu.Unlock()
@@ -195,8 +601,9 @@ func paymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 
 	resp := PaymentResponse{
 		Success:   true,
+		Message:   payment.FailureReason,
 		PaymentID: payment.ID,
-		OrderID:   orderID,
+		OrderID:   payment.OrderID,
 		Status:    payment.Status,
 	}
 
@@ -204,6 +611,810 @@ func paymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func simulatePaymentProcessing(amount float64) bool {
+func listPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orderID := r.URL.Query().Get("order_id")
+
+	mu.Lock()
+	resp := PaymentListResponse{
+		Success:  true,
+		Payments: []Payment{},
+	}
+	for _, p := range payments {
+		if orderID == "" || p.OrderID == orderID {
+			resp.Payments = append(resp.Payments, p)
+		}
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func paymentCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var callback GatewayResult
+	if err := json.NewDecoder(r.Body).Decode(&callback); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	payment, exists := payments[callback.Reference]
+	if !exists || (payment.GatewayReference != "" && payment.GatewayReference != callback.TransactionID) {
+		mu.Unlock()
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+	mu.Unlock()
+
+	if payment.Status != PaymentStatusPending {
+		if callback.Status == GatewayStatusAuthorized {
+			gateway.Void(callback.TransactionID)
+		}
+		w.WriteHeader(http.StatusOK)
+		fmt.Printf("Late callback ignored: %s for payment %s in status %s\n", callback.Status, payment.ID, payment.Status)
+		return
+	}
+
+	status := PaymentStatusSuccess
+	failureReason := ""
+	switch callback.Status {
+	case GatewayStatusAuthorized:
+		capture, err := gateway.Capture(callback.TransactionID, payment.SettlementAmount)
+		if err != nil || capture.Status != GatewayStatusCaptured {
+			status = PaymentStatusFailed
+			failureReason = "Capture failed after asynchronous authorization"
+			if cancelErr := cancelGatewayTransaction(payment.ID, callback.TransactionID); cancelErr != nil {
+				failureReason = fmt.Sprintf("%s (%v)", failureReason, cancelErr)
+			}
+		}
+	case GatewayStatusDeclined:
+		status = PaymentStatusFailed
+		failureReason = "Payment declined by gateway"
+	default:
+		http.Error(w, "Unsupported callback status", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	current := payments[payment.ID]
+	if current.Status != PaymentStatusPending {
+		mu.Unlock()
+		if status == PaymentStatusSuccess {
+			gateway.Refund(callback.TransactionID, payment.SettlementAmount)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	current.Status = status
+	current.FailureReason = failureReason
+	current.GatewayReference = callback.TransactionID
+	payments[payment.ID] = current
+	mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Printf("Payment settled by callback: %s for order %s with status %s\n", payment.ID, payment.OrderID, status)
+}
+
+func refundedStatus(payment Payment) string {
+	switch {
+	case !payment.RefundedAmount.IsPositive():
+		return PaymentStatusSuccess
+	case payment.RefundedAmount.MinorUnits < payment.Amount.MinorUnits:
+		return PaymentStatusPartiallyRefunded
+	}
+	return PaymentStatusRefunded
+}
+
+func simulatePaymentProcessing(amount Money) bool {
 	return true
 }
+
+func chargeCard(paymentID string, amount Money) (string, bool, error) {
+	auth, err := gateway.Authorize(paymentID, amount)
+	if err != nil {
+		if cancelErr := cancelGatewayTransaction(paymentID, ""); cancelErr != nil {
+			return "", false, fmt.Errorf("Payment gateway error: %v (%v)", err, cancelErr)
+		}
+		return "", false, fmt.Errorf("Payment gateway error: %v", err)
+	}
+	if auth.Status == GatewayStatusPending {
+		return auth.TransactionID, true, nil
+	}
+	if auth.Status != GatewayStatusAuthorized {
+		return auth.TransactionID, false, fmt.Errorf("Payment declined by gateway: %s", auth.Message)
+	}
+
+	capture, err := gateway.Capture(auth.TransactionID, amount)
+	if err != nil || capture.Status != GatewayStatusCaptured {
+		cancelErr := cancelGatewayTransaction(paymentID, auth.TransactionID)
+		if err == nil {
+			err = fmt.Errorf("Capture declined by gateway: %s", capture.Message)
+		} else {
+			err = fmt.Errorf("Payment gateway error: %v", err)
+		}
+		if cancelErr != nil {
+			err = fmt.Errorf("%v (%v)", err, cancelErr)
+		}
+		return auth.TransactionID, false, err
+	}
+
+	return auth.TransactionID, false, nil
+}
+
+func cancelGatewayTransaction(paymentID, transactionID string) error {
+	current, err := gateway.Query(paymentID)
+	if err != nil {
+		return fmt.Errorf("gateway transaction state unknown: %v", err)
+	}
+	if transactionID != "" && current.TransactionID != transactionID {
+		return fmt.Errorf("gateway returned transaction %s instead of %s", current.TransactionID, transactionID)
+	}
+
+	var result GatewayResult
+	switch current.Status {
+	case GatewayStatusAuthorized, GatewayStatusPending:
+		result, err = gateway.Void(current.TransactionID)
+	case GatewayStatusCaptured:
+		remaining, _ := current.Amount.Sub(current.RefundedAmount)
+		fmt.Printf("Refunding %s captured on %s for failed payment %s\n", remaining, current.TransactionID, paymentID)
+		result, err = gateway.Refund(current.TransactionID, remaining)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release gateway transaction %s in status %s: %v", current.TransactionID, current.Status, err)
+	}
+	if !result.Approved {
+		return fmt.Errorf("failed to release gateway transaction %s in status %s: %s", current.TransactionID, current.Status, result.Message)
+	}
+	return nil
+}
+
+func queryGatewayTransaction(payment Payment) (GatewayResult, error) {
+	current, err := gateway.Query(payment.ID)
+	if err != nil {
+		return GatewayResult{}, err
+	}
+	if current.TransactionID != payment.GatewayReference {
+		return GatewayResult{}, fmt.Errorf("gateway returned transaction %s instead of %s", current.TransactionID, payment.GatewayReference)
+	}
+	return current, nil
+}
+
+func refundApplied(payment Payment) (bool, error) {
+	current, err := queryGatewayTransaction(payment)
+	if err != nil {
+		return false, err
+	}
+	cmp, err := current.RefundedAmount.Cmp(payment.SettledRefunds)
+	if err != nil {
+		return false, err
+	}
+	return cmp >= 0, nil
+}
+
+func newHTTPPaymentGateway(baseURL, callbackURL string, timeout time.Duration) *httpPaymentGateway {
+	return &httpPaymentGateway{
+		baseURL:     baseURL,
+		callbackURL: callbackURL,
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+func (g *httpPaymentGateway) Authorize(reference string, amount Money) (GatewayResult, error) {
+	return g.post("/authorize", GatewayRequest{Reference: reference, Amount: &amount, CallbackURL: g.callbackURL})
+}
+
+func (g *httpPaymentGateway) Capture(transactionID string, amount Money) (GatewayResult, error) {
+	return g.post("/capture", GatewayRequest{TransactionID: transactionID, Amount: &amount})
+}
+
+func (g *httpPaymentGateway) Refund(transactionID string, amount Money) (GatewayResult, error) {
+	return g.post("/refund", GatewayRequest{TransactionID: transactionID, Amount: &amount})
+}
+
+func (g *httpPaymentGateway) Void(transactionID string) (GatewayResult, error) {
+	return g.post("/void", GatewayRequest{TransactionID: transactionID})
+}
+
+func (g *httpPaymentGateway) Query(reference string) (GatewayResult, error) {
+	resp, err := g.client.Get(g.baseURL + "/query?reference=" + reference)
+	if err != nil {
+		return GatewayResult{}, err
+	}
+	defer resp.Body.Close()
+
+	return decodeGatewayResult(resp)
+}
+
+func (g *httpPaymentGateway) post(path string, gatewayReq GatewayRequest) (GatewayResult, error) {
+	reqBody, err := json.Marshal(gatewayReq)
+	if err != nil {
+		return GatewayResult{}, err
+	}
+
+	resp, err := g.client.Post(g.baseURL+path, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return GatewayResult{}, err
+	}
+	defer resp.Body.Close()
+
+	return decodeGatewayResult(resp)
+}
+
+func decodeGatewayResult(resp *http.Response) (GatewayResult, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return GatewayResult{}, err
+	}
+
+	var result GatewayResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return GatewayResult{}, fmt.Errorf("unexpected gateway response (%d): %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	result.Approved = resp.StatusCode < 300
+	return result, nil
+}
+
+func topUpWalletHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req WalletRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CustomerID == "" {
+		http.Error(w, "Customer ID is required", http.StatusBadRequest)
+		return
+	}
+	if err := req.Amount.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid amount: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !req.Amount.IsPositive() {
+		http.Error(w, "Amount must be greater than zero", http.StatusBadRequest)
+		return
+	}
+
+	amount, _, err := convertToSettlement(req.Amount)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot convert top-up: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	wallet, err := creditWallet(req.CustomerID, amount)
+	mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	resp := walletResponse(wallet)
+	resp.Message = "Wallet topped up successfully"
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Wallet topped up: %s with %s (%s)\n", req.CustomerID, amount, req.Amount)
+}
+
+func walletBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	customerID := r.URL.Query().Get("customer_id")
+	if customerID == "" {
+		http.Error(w, "Customer ID is required", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	wallet, exists := wallets[customerID]
+	mu.Unlock()
+	if !exists {
+		http.Error(w, "Wallet not found", http.StatusNotFound)
+		return
+	}
+
+	resp := walletResponse(wallet)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func holdFundsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req WalletRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CustomerID == "" {
+		http.Error(w, "Customer ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.OrderID == "" {
+		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		return
+	}
+	if err := req.Amount.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid amount: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !req.Amount.IsPositive() {
+		http.Error(w, "Amount must be greater than zero", http.StatusBadRequest)
+		return
+	}
+
+	amount, _, err := convertToSettlement(req.Amount)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot convert hold amount: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	wallet, err := walletFor(req.CustomerID, amount.Currency)
+	if err == nil {
+		if available := availableFunds(wallet); available.MinorUnits < amount.MinorUnits {
+			err = fmt.Errorf("Insufficient funds: available %s, required %s", available, amount)
+		}
+	}
+	if err != nil {
+		mu.Unlock()
+		resp := WalletResponse{
+			Success:    false,
+			Message:    err.Error(),
+			CustomerID: req.CustomerID,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	holdID := fmt.Sprintf("HLD-%d", nextHoldID)
+	nextHoldID++
+
+	holds[holdID] = Hold{
+		ID:         holdID,
+		CustomerID: req.CustomerID,
+		OrderID:    req.OrderID,
+		Amount:     amount,
+		Status:     HoldStatusActive,
+		CreatedAt:  time.Now(),
+	}
+	wallet.Held, _ = wallet.Held.Add(amount)
+	wallet.UpdatedAt = time.Now()
+	wallets[req.CustomerID] = wallet
+	mu.Unlock()
+
+	resp := walletResponse(wallet)
+	resp.Message = "Funds held successfully"
+	resp.HoldID = holdID
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Funds held: %s for order %s with %s\n", holdID, req.OrderID, amount)
+}
+
+func releaseHoldHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		HoldID string `json:"hold_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	hold, exists := holds[req.HoldID]
+	if !exists || hold.Status != HoldStatusActive {
+		mu.Unlock()
+		http.Error(w, "No active hold found", http.StatusNotFound)
+		return
+	}
+
+	hold.Status = HoldStatusReleased
+	holds[req.HoldID] = hold
+
+	wallet := wallets[hold.CustomerID]
+	wallet.Held, _ = wallet.Held.Sub(hold.Amount)
+	wallet.UpdatedAt = time.Now()
+	wallets[hold.CustomerID] = wallet
+	mu.Unlock()
+
+	resp := walletResponse(wallet)
+	resp.Message = "Hold released successfully"
+	resp.HoldID = req.HoldID
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Hold released: %s for order %s\n", req.HoldID, hold.OrderID)
+}
+
+func fraudCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FraudCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.OrderID == "" {
+		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.CustomerID == "" {
+		http.Error(w, "Customer ID is required", http.StatusBadRequest)
+		return
+	}
+	if err := req.Amount.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid amount: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	score, reasons := scoreOrder(req)
+
+	decision := FraudDecisionApprove
+	if score >= FraudRejectThreshold {
+		decision = FraudDecisionReject
+	} else if score >= FraudReviewThreshold {
+		decision = FraudDecisionReview
+	}
+
+	checkID := fmt.Sprintf("FRD-%d", nextFraudCheckID)
+	nextFraudCheckID++
+
+	fraudChecks[checkID] = FraudCheck{
+		ID:         checkID,
+		OrderID:    req.OrderID,
+		CustomerID: req.CustomerID,
+		Amount:     req.Amount,
+		Score:      score,
+		Decision:   decision,
+		Reasons:    reasons,
+		CheckedAt:  time.Now(),
+	}
+	mu.Unlock()
+
+	resp := FraudCheckResponse{
+		Success:  true,
+		Message:  fmt.Sprintf("Fraud check completed with decision %s", decision),
+		CheckID:  checkID,
+		OrderID:  req.OrderID,
+		Score:    score,
+		Decision: decision,
+		Reasons:  reasons,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Fraud check: %s for order %s scored %d (%s)\n", checkID, req.OrderID, score, decision)
+}
+
+func fraudBlocklistHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		mu.Lock()
+		entries := make([]BlocklistEntry, 0, len(blocklist))
+		for _, entry := range blocklist {
+			entries = append(entries, entry)
+		}
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	case http.MethodPost:
+		var entry BlocklistEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if entry.Type != BlocklistTypeCustomer && entry.Type != BlocklistTypeAddress {
+			http.Error(w, "Blocklist type must be customer or address", http.StatusBadRequest)
+			return
+		}
+		if entry.Value == "" {
+			http.Error(w, "Blocklist value is required", http.StatusBadRequest)
+			return
+		}
+
+		entry.AddedAt = time.Now()
+
+		mu.Lock()
+		blocklist[blocklistKey(entry.Type, entry.Value)] = entry
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(entry)
+
+		fmt.Printf("Blocklist entry added: %s %s\n", entry.Type, entry.Value)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func fxRatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FXRateListResponse{
+		Success:            true,
+		SettlementCurrency: SettlementCurrency,
+		Rates:              fxRates,
+	})
+}
+
+func scoreOrder(req FraudCheckRequest) (int, []string) {
+	score := 0
+	reasons := []string{}
+
+	if _, blocked := blocklist[blocklistKey(BlocklistTypeCustomer, req.CustomerID)]; blocked {
+		score += 100
+		reasons = append(reasons, "customer is blocklisted")
+	}
+	if _, blocked := blocklist[blocklistKey(BlocklistTypeAddress, req.ShippingAddress)]; blocked {
+		score += 100
+		reasons = append(reasons, "shipping address is blocklisted")
+	}
+
+	recent := 0
+	for _, check := range fraudChecks {
+		if check.CustomerID == req.CustomerID && time.Since(check.CheckedAt) <= FraudVelocityWindow {
+			recent++
+		}
+	}
+	if recent >= FraudVelocityLimit {
+		score += 30
+		reasons = append(reasons, fmt.Sprintf("%d orders from customer in the last %s", recent+1, FraudVelocityWindow))
+	}
+
+	amount, _, err := convertToSettlement(req.Amount)
+	if err != nil {
+		reasons = append(reasons, fmt.Sprintf("amount checks skipped: %v", err))
+		amount = Money{}
+	}
+
+	total := Money{Currency: SettlementCurrency}
+	count := 0
+	for _, p := range payments {
+		if p.CustomerID == req.CustomerID && (p.Status == PaymentStatusSuccess || p.Status == PaymentStatusRefunded || p.Status == PaymentStatusPartiallyRefunded) {
+			total, _ = total.Add(p.SettlementAmount)
+			count++
+		}
+	}
+	switch {
+	case amount.Currency == "":
+	case count >= FraudMinHistory:
+		average := total.MulRat(big.NewRat(1, int64(count)))
+		if limit := average.Mul(FraudAmountAnomalyFactor); amount.MinorUnits > limit.MinorUnits {
+			score += 30
+			reasons = append(reasons, fmt.Sprintf("amount %s is more than %dx the customer average of %s", amount, FraudAmountAnomalyFactor, average))
+		}
+	case amount.MinorUnits > fraudHighAmount.MinorUnits:
+		score += 20
+		reasons = append(reasons, fmt.Sprintf("amount %s exceeds %s for a customer without payment history", amount, fraudHighAmount))
+	}
+
+	if req.BillingAddress != "" && normalizeAddress(req.BillingAddress) != normalizeAddress(req.ShippingAddress) {
+		score += 20
+		reasons = append(reasons, "billing address does not match shipping address")
+	}
+
+	if score > 100 {
+		score = 100
+	}
+	return score, reasons
+}
+
+func blocklistKey(entryType, value string) string {
+	if entryType == BlocklistTypeAddress {
+		value = normalizeAddress(value)
+	}
+	return entryType + ":" + value
+}
+
+func normalizeAddress(address string) string {
+	return strings.Join(strings.Fields(strings.ToLower(address)), " ")
+}
+
+func debitWallet(customerID, orderID string, amount Money) error {
+	wallet, err := walletFor(customerID, amount.Currency)
+	if err != nil {
+		return err
+	}
+
+	for id, hold := range holds {
+		if hold.OrderID == orderID && hold.CustomerID == customerID && hold.Status == HoldStatusActive && hold.Amount.MinorUnits >= amount.MinorUnits {
+			hold.Status = HoldStatusCaptured
+			holds[id] = hold
+
+			wallet.Held, _ = wallet.Held.Sub(hold.Amount)
+			wallet.Balance, _ = wallet.Balance.Sub(amount)
+			wallet.UpdatedAt = time.Now()
+			wallets[customerID] = wallet
+			return nil
+		}
+	}
+
+	available := availableFunds(wallet)
+	if available.MinorUnits < amount.MinorUnits {
+		return fmt.Errorf("Insufficient funds in wallet of customer %s: available %s, required %s", customerID, available, amount)
+	}
+
+	wallet.Balance, _ = wallet.Balance.Sub(amount)
+	wallet.UpdatedAt = time.Now()
+	wallets[customerID] = wallet
+	return nil
+}
+
+func creditWallet(customerID string, amount Money) (Wallet, error) {
+	wallet, err := walletFor(customerID, amount.Currency)
+	if err != nil {
+		return wallet, err
+	}
+
+	wallet.Balance, _ = wallet.Balance.Add(amount)
+	wallet.UpdatedAt = time.Now()
+	wallets[customerID] = wallet
+	return wallet, nil
+}
+
+func walletFor(customerID, currency string) (Wallet, error) {
+	wallet, exists := wallets[customerID]
+	if !exists {
+		return Wallet{
+			CustomerID: customerID,
+			Balance:    Money{Currency: currency},
+			Held:       Money{Currency: currency},
+		}, nil
+	}
+	if wallet.Balance.Currency != currency {
+		return wallet, fmt.Errorf("Wallet of customer %s: %w: wallet holds %s, amount is in %s", customerID, money.ErrCurrencyMismatch, wallet.Balance.Currency, currency)
+	}
+	return wallet, nil
+}
+
+func availableFunds(wallet Wallet) Money {
+	available, _ := wallet.Balance.Sub(wallet.Held)
+	return available
+}
+
+func walletResponse(wallet Wallet) WalletResponse {
+	return WalletResponse{
+		Success:    true,
+		CustomerID: wallet.CustomerID,
+		Balance:    wallet.Balance,
+		Held:       wallet.Held,
+		Available:  availableFunds(wallet),
+	}
+}
+
+func loadFXRates(path string) ([]FXRate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []FXRate
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, err
+	}
+	for _, rate := range rates {
+		if err := (Money{Currency: rate.Base}).Validate(); err != nil {
+			return nil, err
+		}
+		if err := (Money{Currency: rate.Quote}).Validate(); err != nil {
+			return nil, err
+		}
+		value, ok := new(big.Rat).SetString(rate.Rate)
+		if !ok || value.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %q for %s/%s", rate.Rate, rate.Base, rate.Quote)
+		}
+	}
+	return rates, nil
+}
+
+func findFXRate(from, to string, at time.Time) (FXRate, error) {
+	if from == to {
+		return FXRate{Base: from, Quote: to, Rate: "1"}, nil
+	}
+
+	var found FXRate
+	for _, rate := range fxRates {
+		if rate.EffectiveFrom.After(at) || (!found.EffectiveFrom.IsZero() && rate.EffectiveFrom.Before(found.EffectiveFrom)) {
+			continue
+		}
+		switch {
+		case rate.Base == from && rate.Quote == to:
+			found = rate
+		case rate.Base == to && rate.Quote == from:
+			inverse, _ := new(big.Rat).SetString(rate.Rate)
+			found = FXRate{
+				Base:          from,
+				Quote:         to,
+				Rate:          inverse.Inv(inverse).RatString(),
+				EffectiveFrom: rate.EffectiveFrom,
+			}
+		}
+	}
+	if found.Rate == "" {
+		return FXRate{}, fmt.Errorf("no FX rate from %s to %s", from, to)
+	}
+	return found, nil
+}
+
+func applyFXRate(amount Money, to string, rate FXRate) (Money, error) {
+	if rate.Base != amount.Currency || rate.Quote != to {
+		return Money{}, fmt.Errorf("rate %s/%s cannot convert %s to %s", rate.Base, rate.Quote, amount.Currency, to)
+	}
+	value, ok := new(big.Rat).SetString(rate.Rate)
+	if !ok {
+		return Money{}, fmt.Errorf("invalid rate %q", rate.Rate)
+	}
+
+	converted := new(big.Rat).Mul(new(big.Rat).SetInt64(amount.MinorUnits), value)
+	toExponent, _ := money.Exponent(to)
+	fromExponent, _ := money.Exponent(amount.Currency)
+	shift := toExponent - fromExponent
+	for ; shift > 0; shift-- {
+		converted.Mul(converted, big.NewRat(10, 1))
+	}
+	for ; shift < 0; shift++ {
+		converted.Mul(converted, big.NewRat(1, 10))
+	}
+	return Money{MinorUnits: money.RoundHalfEven(converted), Currency: to}, nil
+}
+
+func convertToSettlement(amount Money) (Money, FXRate, error) {
+	rate, err := findFXRate(amount.Currency, SettlementCurrency, time.Now())
+	if err != nil {
+		return Money{}, FXRate{}, err
+	}
+	settled, err := applyFXRate(amount, SettlementCurrency, rate)
+	if err != nil {
+		return Money{}, FXRate{}, err
+	}
+	return settled, rate, nil
+}
@@ -1,49 +1,419 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/address"
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/money"
 )
 
 const (
-	ShippingStatusPending   = "PENDING"
-	ShippingStatusShipped   = "SHIPPED"
-	ShippingStatusCancelled = "CANCELLED"
+	ShippingStatusPending        = "PENDING"
+	ShippingStatusLabelCreated   = "LABEL_CREATED"
+	ShippingStatusPickedUp       = "PICKED_UP"
+	ShippingStatusInTransit      = "IN_TRANSIT"
+	ShippingStatusOutForDelivery = "OUT_FOR_DELIVERY"
+	ShippingStatusDelivered      = "DELIVERED"
+	ShippingStatusException      = "EXCEPTION"
+	ShippingStatusReturned       = "RETURNED"
+	ShippingStatusCancelled      = "CANCELLED"
 )
 
-type Shipping struct {
+const ShippingStatusPartiallyDelivered = "PARTIALLY_DELIVERED"
+
+var shippingProgress = map[string]int{
+	ShippingStatusPending:        0,
+	ShippingStatusLabelCreated:   1,
+	ShippingStatusPickedUp:       2,
+	ShippingStatusInTransit:      3,
+	ShippingStatusOutForDelivery: 4,
+	ShippingStatusDelivered:      5,
+}
+
+var shippingTransitions = map[string][]string{
+	ShippingStatusPending:        {ShippingStatusLabelCreated, ShippingStatusPickedUp, ShippingStatusException},
+	ShippingStatusLabelCreated:   {ShippingStatusPickedUp, ShippingStatusException},
+	ShippingStatusPickedUp:       {ShippingStatusInTransit, ShippingStatusException},
+	ShippingStatusInTransit:      {ShippingStatusInTransit, ShippingStatusOutForDelivery, ShippingStatusDelivered, ShippingStatusException, ShippingStatusReturned},
+	ShippingStatusOutForDelivery: {ShippingStatusDelivered, ShippingStatusException},
+	ShippingStatusException:      {ShippingStatusInTransit, ShippingStatusOutForDelivery, ShippingStatusDelivered, ShippingStatusReturned},
+}
+
+var ErrIllegalTransition = errors.New("illegal shipment status transition")
+
+var ErrReturningToSender = errors.New("shipment is being returned to sender")
+
+var returnableStatuses = []string{
+	ShippingStatusPickedUp,
+	ShippingStatusInTransit,
+	ShippingStatusOutForDelivery,
+	ShippingStatusException,
+}
+
+const (
+	ShippingTypeOutbound = "OUTBOUND"
+	ShippingTypeReturn   = "RETURN"
+)
+
+const CarriersFile = "carriers.json"
+
+const WarehousesFile = "warehouses.json"
+
+const HolidaysFile = "holidays.json"
+
+const DateFormat = "2006-01-02"
+
+const (
+	TransitDaysDomestic        = 0
+	TransitDaysSameZone        = 1
+	TransitDaysCrossZone       = 3
+	ExceptionDelayBusinessDays = 2
+)
+
+var countryZones = map[string]string{
+	"AU": "APAC",
+	"CA": "AMERICAS",
+	"DE": "EUROPE",
+	"FR": "EUROPE",
+	"GB": "EUROPE",
+	"ID": "APAC",
+	"JP": "APAC",
+	"NL": "EUROPE",
+	"SG": "APAC",
+	"US": "AMERICAS",
+}
+
+const LabelsDir = "labels"
+
+const (
+	LabelStatusActive = "ACTIVE"
+	LabelStatusVoided = "VOIDED"
+)
+
+const (
+	LabelFormatZPL = "zpl"
+	LabelFormatPNG = "png"
+)
+
+const (
+	BarcodeNarrowWidth = 2
+	BarcodeWideWidth   = 5
+	BarcodeHeight      = 80
+	BarcodeQuietZone   = 20
+)
+
+var code39Patterns = map[rune]string{
+	'0': "nnnwwnwnn", '1': "wnnwnnnnw", '2': "nnwwnnnnw", '3': "wnwwnnnnn", '4': "nnnwwnnnw",
+	'5': "wnnwwnnnn", '6': "nnwwwnnnn", '7': "nnnwnnwnw", '8': "wnnwnnwnn", '9': "nnwwnnwnn",
+	'A': "wnnnnwnnw", 'B': "nnwnnwnnw", 'C': "wnwnnwnnn", 'D': "nnnnwwnnw", 'E': "wnnnwwnnn",
+	'F': "nnwnwwnnn", 'G': "nnnnnwwnw", 'H': "wnnnnwwnn", 'I': "nnwnnwwnn", 'J': "nnnnwwwnn",
+	'K': "wnnnnnnww", 'L': "nnwnnnnww", 'M': "wnwnnnnwn", 'N': "nnnnwnnww", 'O': "wnnnwnnwn",
+	'P': "nnwnwnnwn", 'Q': "nnnnnnwww", 'R': "wnnnnnwwn", 'S': "nnwnnnwwn", 'T': "nnnnwnwwn",
+	'U': "wwnnnnnnw", 'V': "nwwnnnnnw", 'W': "wwwnnnnnn", 'X': "nwnnwnnnw", 'Y': "wwnnwnnnn",
+	'Z': "nwwnwnnnn", '-': "nwnnnnwnw", '*': "nwnnwnwnn",
+}
+
+const ShippingCurrency = "USD"
+
+const InventoryServiceURL = "http://localhost:8084"
+
+const (
+	PreferenceCheapest = "CHEAPEST"
+	PreferenceFastest  = "FASTEST"
+)
+
+var (
+	ErrWeightExceeded   = errors.New("parcel exceeds carrier weight limit")
+	ErrCountryNotServed = errors.New("carrier does not deliver to country")
+	ErrNoRate           = errors.New("no carrier rate available")
+
+	ErrInsufficientStock = errors.New("insufficient warehouse stock")
+)
+
+type Money = money.Money
+
+type Carrier interface {
+	Name() string
+	Quote(weightGrams int, country string) ([]Rate, error)
+}
+
+type Rate struct {
+	Carrier      string `json:"carrier"`
+	ServiceLevel string `json:"service_level"`
+	Price        Money  `json:"price"`
+	MinDays      int    `json:"min_days"`
+	MaxDays      int    `json:"max_days"`
+}
+
+type CarrierConfig struct {
+	Name           string          `json:"name"`
+	MaxWeightGrams int             `json:"max_weight_grams"`
+	Countries      []string        `json:"countries,omitempty"`
+	Services       []ServiceConfig `json:"services"`
+}
+
+type ServiceConfig struct {
+	Level   string `json:"level"`
+	Base    int64  `json:"base"`
+	PerKg   int64  `json:"per_kg"`
+	MinDays int    `json:"min_days"`
+	MaxDays int    `json:"max_days"`
+}
+
+type rateTableCarrier struct {
+	config CarrierConfig
+}
+
+type Address = address.Address
+
+type AddressValidationResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message"`
+	Errors  []address.FieldError `json:"errors,omitempty"`
+}
+
+type Warehouse struct {
 	ID      string `json:"id"`
-	OrderID string `json:"order_id"`
-	Address string `json:"address"`
-	Status  string `json:"status"`
+	Country string `json:"country"`
+	Zone    string `json:"zone"`
+}
+
+type StockLevelResponse struct {
+	Success bool         `json:"success"`
+	Levels  []StockLevel `json:"levels"`
+}
+
+type StockLevel struct {
+	ItemID     string           `json:"item_id"`
+	Warehouses []WarehouseLevel `json:"warehouses"`
+}
+
+type WarehouseLevel struct {
+	WarehouseID string `json:"warehouse_id"`
+	Available   int    `json:"available"`
+}
+
+type ShipmentItem struct {
+	ID          string `json:"id"`
+	Quantity    int    `json:"quantity"`
+	WeightGrams int    `json:"weight_grams,omitempty"`
+}
+
+type Shipping struct {
+	ID                string            `json:"id"`
+	OrderID           string            `json:"order_id"`
+	Type              string            `json:"type"`
+	ReturnID          string            `json:"return_id,omitempty"`
+	Address           Address           `json:"address"`
+	WarehouseID       string            `json:"warehouse_id,omitempty"`
+	Items             []ShipmentItem    `json:"items,omitempty"`
+	Carrier           string            `json:"carrier"`
+	ServiceLevel      string            `json:"service_level"`
+	WeightGrams       int               `json:"weight_grams"`
+	Cost              Money             `json:"cost"`
+	MinDays           int               `json:"min_days"`
+	MaxDays           int               `json:"max_days"`
+	TrackingNumber    string            `json:"tracking_number"`
+	Label             *Label            `json:"label,omitempty"`
+	Status            string            `json:"status"`
+	ReturnToSender    bool              `json:"return_to_sender,omitempty"`
+	EstimatedDelivery *DeliveryEstimate `json:"estimated_delivery,omitempty"`
+	Events            []TrackingEvent   `json:"events"`
+}
+
+type DeliveryEstimate struct {
+	EarliestDate string    `json:"earliest_date"`
+	LatestDate   string    `json:"latest_date"`
+	Basis        string    `json:"basis"`
+	CalculatedAt time.Time `json:"calculated_at"`
+}
+
+type Label struct {
+	Status      string    `json:"status"`
+	ZPLFile     string    `json:"zpl_file"`
+	BarcodeFile string    `json:"barcode_file"`
+	CreatedAt   time.Time `json:"created_at"`
+	VoidedAt    time.Time `json:"voided_at,omitempty"`
+}
+
+type TrackingEvent struct {
+	Status      string    `json:"status"`
+	Location    string    `json:"location,omitempty"`
+	Description string    `json:"description,omitempty"`
+	OccurredAt  time.Time `json:"occurred_at"`
+	RecordedAt  time.Time `json:"recorded_at"`
+}
+
+type TrackingEventRequest struct {
+	Status      string    `json:"status"`
+	Location    string    `json:"location,omitempty"`
+	Description string    `json:"description,omitempty"`
+	OccurredAt  time.Time `json:"occurred_at,omitempty"`
+}
+
+type TrackingResponse struct {
+	Success           bool              `json:"success"`
+	ShippingID        string            `json:"shipping_id"`
+	OrderID           string            `json:"order_id"`
+	Type              string            `json:"type"`
+	Carrier           string            `json:"carrier"`
+	ServiceLevel      string            `json:"service_level"`
+	TrackingNumber    string            `json:"tracking_number"`
+	Status            string            `json:"status"`
+	ReturnToSender    bool              `json:"return_to_sender,omitempty"`
+	EstimatedDelivery *DeliveryEstimate `json:"estimated_delivery,omitempty"`
+	Events            []TrackingEvent   `json:"events"`
 }
 
 type StartShippingRequest struct {
-	OrderID string `json:"order_id"`
-	Address string `json:"address"`
+	OrderID      string         `json:"order_id"`
+	Address      Address        `json:"address"`
+	Type         string         `json:"type,omitempty"`
+	ReturnID     string         `json:"return_id,omitempty"`
+	WarehouseID  string         `json:"warehouse_id,omitempty"`
+	Items        []ShipmentItem `json:"items,omitempty"`
+	WeightGrams  int            `json:"weight_grams,omitempty"`
+	Carrier      string         `json:"carrier,omitempty"`
+	ServiceLevel string         `json:"service_level,omitempty"`
+	Preference   string         `json:"preference,omitempty"`
+}
+
+type PlanShipmentsRequest struct {
+	OrderID string         `json:"order_id"`
+	Address Address        `json:"address"`
+	Items   []ShipmentItem `json:"items"`
+}
+
+type PlannedShipment struct {
+	WarehouseID string         `json:"warehouse_id"`
+	Origin      string         `json:"origin"`
+	Items       []ShipmentItem `json:"items"`
+	WeightGrams int            `json:"weight_grams"`
+}
+
+type PlanShipmentsResponse struct {
+	Success   bool              `json:"success"`
+	Message   string            `json:"message"`
+	OrderID   string            `json:"order_id,omitempty"`
+	Shipments []PlannedShipment `json:"shipments,omitempty"`
+}
+
+type WarehousesResponse struct {
+	Success    bool        `json:"success"`
+	Warehouses []Warehouse `json:"warehouses"`
+}
+
+type ReceiveReturnRequest struct {
+	ShippingID string `json:"shipping_id"`
+}
+
+type UpdateShippingRequest struct {
+	OrderID    string  `json:"order_id"`
+	ShippingID string  `json:"shipping_id,omitempty"`
+	Address    Address `json:"address"`
 }
 
 type ShippingResponse struct {
-	Success    bool   `json:"success"`
-	Message    string `json:"message"`
-	ShippingID string `json:"shipping_id,omitempty"`
-	OrderID    string `json:"order_id,omitempty"`
-	Status     string `json:"status,omitempty"`
+	Success           bool              `json:"success"`
+	Message           string            `json:"message"`
+	ShippingID        string            `json:"shipping_id,omitempty"`
+	OrderID           string            `json:"order_id,omitempty"`
+	Type              string            `json:"type,omitempty"`
+	ReturnID          string            `json:"return_id,omitempty"`
+	Address           *Address          `json:"address,omitempty"`
+	WarehouseID       string            `json:"warehouse_id,omitempty"`
+	Items             []ShipmentItem    `json:"items,omitempty"`
+	Carrier           string            `json:"carrier,omitempty"`
+	ServiceLevel      string            `json:"service_level,omitempty"`
+	TrackingNumber    string            `json:"tracking_number,omitempty"`
+	WeightGrams       int               `json:"weight_grams,omitempty"`
+	Cost              *Money            `json:"cost,omitempty"`
+	Status            string            `json:"status,omitempty"`
+	ReturnToSender    bool              `json:"return_to_sender,omitempty"`
+	EstimatedDelivery *DeliveryEstimate `json:"estimated_delivery,omitempty"`
+	Shipments         []ShipmentSummary `json:"shipments,omitempty"`
+}
+
+type ShipmentSummary struct {
+	ShippingID        string            `json:"shipping_id"`
+	WarehouseID       string            `json:"warehouse_id,omitempty"`
+	Items             []ShipmentItem    `json:"items,omitempty"`
+	Carrier           string            `json:"carrier"`
+	ServiceLevel      string            `json:"service_level"`
+	TrackingNumber    string            `json:"tracking_number"`
+	WeightGrams       int               `json:"weight_grams"`
+	Cost              Money             `json:"cost"`
+	Status            string            `json:"status"`
+	EstimatedDelivery *DeliveryEstimate `json:"estimated_delivery,omitempty"`
+}
+
+type ShippingQuotesResponse struct {
+	Success     bool   `json:"success"`
+	WeightGrams int    `json:"weight_grams"`
+	Country     string `json:"country"`
+	Preference  string `json:"preference"`
+	Rates       []Rate `json:"rates"`
 }
 
 var (
 	shippings = make(map[string]Shipping)
 	mu        sync.Mutex
 	nextID    = 1
+
+	carriers   []Carrier
+	warehouses []Warehouse
+	holidays   map[string]map[string]bool
 )
 
 func main() {
+	loaded, err := loadCarriers(CarriersFile)
+	if err != nil {
+		log.Fatalf("Failed to load carriers from %s: %v", CarriersFile, err)
+	}
+	carriers = loaded
+
+	stock, err := loadWarehouses(WarehousesFile)
+	if err != nil {
+		log.Fatalf("Failed to load warehouses from %s: %v", WarehousesFile, err)
+	}
+	warehouses = stock
+
+	calendar, err := loadHolidays(HolidaysFile)
+	if err != nil {
+		log.Fatalf("Failed to load holidays from %s: %v", HolidaysFile, err)
+	}
+	holidays = calendar
+
+	if err := os.MkdirAll(LabelsDir, 0755); err != nil {
+		log.Fatalf("Failed to create label directory %s: %v", LabelsDir, err)
+	}
+
 	http.HandleFunc("/start-shipping", startShippingHandler)
 	http.HandleFunc("/cancel-shipping", cancelShippingHandler)
+	http.HandleFunc("/update-shipping", updateShippingHandler)
+	http.HandleFunc("/receive-return", receiveReturnHandler)
+	http.HandleFunc("/return-to-sender", returnToSenderHandler)
 	http.HandleFunc("/shipping-status", shippingStatusHandler)
+	http.HandleFunc("/shipping-quotes", shippingQuotesHandler)
+	http.HandleFunc("/plan-shipments", planShipmentsHandler)
+	http.HandleFunc("/warehouses", warehousesHandler)
+	http.HandleFunc("/shipments/", shipmentHandler)
 
 	fmt.Println("Shipping Service started on :8083")
 	log.Fatal(http.ListenAndServe(":8083", nil))
@@ -65,36 +435,142 @@ func startShippingHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Order ID is required", http.StatusBadRequest)
 		return
 	}
-	if req.Address == "" {
-		http.Error(w, "Shipping address is required", http.StatusBadRequest)
+	normalized, err := address.Normalize(req.Address)
+	if err != nil {
+		writeAddressError(w, "Invalid shipping address", err)
+		return
+	}
+	req.Address = normalized
+	if req.Type == "" {
+		req.Type = ShippingTypeOutbound
+	}
+	if req.Type != ShippingTypeOutbound && req.Type != ShippingTypeReturn {
+		http.Error(w, "Unsupported shipping type", http.StatusBadRequest)
+		return
+	}
+	if req.Type == ShippingTypeReturn && req.ReturnID == "" {
+		http.Error(w, "Return ID is required for return shipments", http.StatusBadRequest)
+		return
+	}
+	if req.WeightGrams < 0 {
+		http.Error(w, "Weight must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.WarehouseID != "" && req.Type != ShippingTypeOutbound {
+		http.Error(w, "Only outbound shipments are fulfilled from a warehouse", http.StatusBadRequest)
+		return
+	}
+	if req.WarehouseID != "" && len(req.Items) == 0 {
+		http.Error(w, "Items are required when shipping from a warehouse", http.StatusBadRequest)
+		return
+	}
+	if err := validateShipmentItems(req.Items); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.WeightGrams == 0 {
+		req.WeightGrams = shipmentWeight(req.Items)
+	}
+	if req.Preference == "" {
+		req.Preference = PreferenceCheapest
+	}
+	if req.Preference != PreferenceCheapest && req.Preference != PreferenceFastest {
+		http.Error(w, "Unsupported carrier preference", http.StatusBadRequest)
+		return
+	}
+
+	rate, err := selectRate(req.WeightGrams, req.Address.Country, req.Carrier, req.ServiceLevel, req.Preference)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
 
 	shippingSuccess := simulateShippingProcess()
 
+	if req.WarehouseID != "" && findWarehouse(req.WarehouseID) == nil {
+		http.Error(w, fmt.Sprintf("Unknown warehouse %s", req.WarehouseID), http.StatusBadRequest)
+		return
+	}
+
 	mu.Lock()
 	shippingID := fmt.Sprintf("SHP-%d", nextID)
+	trackingNumber := fmt.Sprintf("%.2s%08d", rate.Carrier, nextID)
 	nextID++
 
+	shipping := Shipping{
+		ID:             shippingID,
+		OrderID:        req.OrderID,
+		Type:           req.Type,
+		ReturnID:       req.ReturnID,
+		Address:        req.Address,
+		WarehouseID:    req.WarehouseID,
+		Items:          req.Items,
+		Carrier:        rate.Carrier,
+		ServiceLevel:   rate.ServiceLevel,
+		WeightGrams:    req.WeightGrams,
+		Cost:           rate.Price,
+		MinDays:        rate.MinDays,
+		MaxDays:        rate.MaxDays,
+		TrackingNumber: trackingNumber,
+	}
+
+	description := "Shipment created"
+	if shippingSuccess {
+		label, err := writeLabel(shipping)
+		if err != nil {
+			fmt.Printf("Failed to generate label for %s: %v\n", shippingID, err)
+			shippingSuccess = false
+			description = "Shipping label could not be generated"
+		}
+		shipping.Label = label
+	} else {
+		description = "Shipment could not be created"
+	}
+
 	status := ShippingStatusPending
 	if !shippingSuccess {
 		status = ShippingStatusCancelled
 	}
 
-	shipping := Shipping{
-		ID:      shippingID,
-		OrderID: req.OrderID,
-		Address: req.Address,
-		Status:  status,
+	now := time.Now()
+	shipping.Events = []TrackingEvent{
+		{
+			Status:      status,
+			Description: description,
+			OccurredAt:  now,
+			RecordedAt:  now,
+		},
 	}
+	if shippingSuccess {
+		status = ShippingStatusLabelCreated
+		shipping.Events = append(shipping.Events, TrackingEvent{
+			Status:      status,
+			Location:    req.WarehouseID,
+			Description: "Shipping label generated",
+			OccurredAt:  now,
+			RecordedAt:  now,
+		})
+	}
+	shipping.Status = status
+	shipping.EstimatedDelivery = estimateDelivery(shipping)
 	shippings[shippingID] = shipping
 	mu.Unlock()
 
 	resp := ShippingResponse{
-		Success:    shippingSuccess,
-		ShippingID: shippingID,
-		OrderID:    req.OrderID,
-		Status:     status,
+		Success:           shippingSuccess,
+		ShippingID:        shippingID,
+		OrderID:           req.OrderID,
+		Type:              req.Type,
+		ReturnID:          req.ReturnID,
+		WarehouseID:       req.WarehouseID,
+		Items:             req.Items,
+		Carrier:           rate.Carrier,
+		ServiceLevel:      rate.ServiceLevel,
+		TrackingNumber:    trackingNumber,
+		WeightGrams:       req.WeightGrams,
+		Cost:              &shipping.Cost,
+		Status:            status,
+		EstimatedDelivery: shipping.EstimatedDelivery,
 	}
 
 	if shippingSuccess {
@@ -108,7 +584,7 @@ func startShippingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 
-	fmt.Printf("Shipping initiated: %s for order %s with status %s\n", shippingID, req.OrderID, status)
+	fmt.Printf("Shipping initiated: %s (%s) for order %s via %s %s (%s) with status %s\n", shippingID, req.Type, req.OrderID, rate.Carrier, rate.ServiceLevel, rate.Price, status)
 }
 
 func cancelShippingHandler(w http.ResponseWriter, r *http.Request) {
@@ -118,7 +594,8 @@ func cancelShippingHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		OrderID string `json:"order_id"`
+		OrderID    string `json:"order_id"`
+		ShippingID string `json:"shipping_id,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -126,26 +603,43 @@ func cancelShippingHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	mu.Lock()
-	var shippingID string
-	var shipping Shipping
-	var found bool
-
-	for id, s := range shippings {
-		if s.OrderID == req.OrderID && s.Status != ShippingStatusCancelled {
-			shippingID = id
-			shipping = s
-			found = true
-			break
-		}
-	}
+	shipping, found := findActiveShipping(req.OrderID, req.ShippingID)
+	shippingID := shipping.ID
 
 	if !found {
 		mu.Unlock()
 		http.Error(w, "No active shipping found for the order", http.StatusNotFound)
 		return
 	}
+	if !shippingEditable(shipping.Status) {
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ShippingResponse{
+			Success:    false,
+			Message:    fmt.Sprintf("Shipping %s is %s and has already been handed to the carrier", shippingID, shipping.Status),
+			ShippingID: shippingID,
+			OrderID:    shipping.OrderID,
+			Status:     shipping.Status,
+		})
+		return
+	}
 
+	now := time.Now()
 	shipping.Status = ShippingStatusCancelled
+	shipping.Events = append(shipping.Events, TrackingEvent{
+		Status:      ShippingStatusCancelled,
+		Description: "Shipment cancelled",
+		OccurredAt:  now,
+		RecordedAt:  now,
+	})
+	if shipping.Label != nil {
+		voided := *shipping.Label
+		voided.Status = LabelStatusVoided
+		voided.VoidedAt = now
+		shipping.Label = &voided
+	}
+	shipping.EstimatedDelivery = nil
 	shippings[shippingID] = shipping
 	mu.Unlock()
 
@@ -163,62 +657,1284 @@ func cancelShippingHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("Shipping cancelled: %s for order %s\n", shippingID, req.OrderID)
 }
 
-func shippingStatusHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+func updateShippingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	orderID := r.URL.Query().Get("order_id")
-	if orderID == "" {
-		http.Error(w, "Order ID is required", http.StatusBadRequest)
+	var req UpdateShippingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	normalized, err := address.Normalize(req.Address)
+	if err != nil {
+		writeAddressError(w, "Invalid shipping address", err)
 		return
 	}
+	req.Address = normalized
 
 	mu.Lock()
-	var shipping Shipping
-	var found bool
+	var targets []Shipping
+	if req.ShippingID != "" {
+		if shipping, found := findActiveShipping(req.OrderID, req.ShippingID); found {
+			targets = append(targets, shipping)
+		}
+	} else {
+		targets = activeOutboundShipments(req.OrderID)
+	}
+	if len(targets) == 0 {
+		mu.Unlock()
+		http.Error(w, "No active shipping found for the order", http.StatusNotFound)
+		return
+	}
+	for i, shipping := range targets {
+		if !shippingEditable(shipping.Status) {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Shipping %s is %s and can no longer be changed", shipping.ID, shipping.Status), http.StatusConflict)
+			return
+		}
 
-	for _, s := range shippings {
-		if s.OrderID == orderID {
-			shipping = s
-			found = true
-			break
+		rate, err := selectRate(shipping.WeightGrams, req.Address.Country, shipping.Carrier, shipping.ServiceLevel, PreferenceCheapest)
+		if err != nil {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Shipping %s: %v", shipping.ID, err), http.StatusUnprocessableEntity)
+			return
+		}
+		targets[i].Address = req.Address
+		targets[i].Cost = rate.Price
+		targets[i].EstimatedDelivery = estimateDelivery(targets[i])
+	}
+
+	for i, shipping := range targets {
+		if shipping.Label != nil {
+			label, err := writeLabel(shipping)
+			if err != nil {
+				mu.Unlock()
+				http.Error(w, fmt.Sprintf("Failed to regenerate shipping label for %s: %v", shipping.ID, err), http.StatusInternalServerError)
+				return
+			}
+			targets[i].Label = label
 		}
 	}
+	for _, shipping := range targets {
+		shippings[shipping.ID] = shipping
+	}
 	mu.Unlock()
 
-	if !found {
-		http.Error(w, "No shipping found for the order", http.StatusNotFound)
+	resp := aggregateShippingResponse(req.OrderID, targets)
+	resp.Message = "Shipping updated successfully"
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	for _, shipping := range targets {
+		fmt.Printf("Shipping updated: %s for order %s\n", shipping.ID, shipping.OrderID)
+	}
+}
+
+func receiveReturnHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ReceiveReturnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	shipping, exists := shippings[req.ShippingID]
+	if !exists || shipping.Type != ShippingTypeReturn {
+		mu.Unlock()
+		http.Error(w, "Return shipment not found", http.StatusNotFound)
+		return
+	}
+	if shipping.Status == ShippingStatusCancelled || shipping.Status == ShippingStatusReturned {
+		mu.Unlock()
+		http.Error(w, fmt.Sprintf("Return shipment %s is %s", shipping.ID, shipping.Status), http.StatusConflict)
 		return
 	}
 
+	if shipping.Status != ShippingStatusDelivered {
+		now := time.Now()
+		shipping.Status = ShippingStatusDelivered
+		shipping.Events = append(shipping.Events, TrackingEvent{
+			Status:      ShippingStatusDelivered,
+			Description: "Return received at warehouse",
+			OccurredAt:  now,
+			RecordedAt:  now,
+		})
+		shippings[shipping.ID] = shipping
+	}
+	mu.Unlock()
+
 	resp := ShippingResponse{
 		Success:    true,
+		Message:    "Return shipment received",
 		ShippingID: shipping.ID,
-		OrderID:    orderID,
+		OrderID:    shipping.OrderID,
+		Type:       shipping.Type,
+		ReturnID:   shipping.ReturnID,
+		Address:    &shipping.Address,
 		Status:     shipping.Status,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
-}
 
-func simulateShippingProcess() bool {
-	return true
+	fmt.Printf("Return shipment received: %s for order %s\n", shipping.ID, shipping.OrderID)
 }
 
-func completeShipping(shippingID string) bool {
+func returnToSenderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		OrderID    string `json:"order_id"`
+		ShippingID string `json:"shipping_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
 	mu.Lock()
-	defer mu.Unlock()
+	shipping, exists := shippings[req.ShippingID]
+	if !exists || shipping.OrderID != req.OrderID || shipping.Type != ShippingTypeOutbound {
+		mu.Unlock()
+		http.Error(w, "Outbound shipment not found for the order", http.StatusNotFound)
+		return
+	}
 
-	shipping, exists := shippings[shippingID]
-	if !exists || shipping.Status != ShippingStatusPending {
-		return false
+	returnable := false
+	for _, status := range returnableStatuses {
+		if shipping.Status == status {
+			returnable = true
+			break
+		}
+	}
+	if !returnable {
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ShippingResponse{
+			Success:    false,
+			Message:    fmt.Sprintf("Shipping %s is %s and cannot be returned to sender", shipping.ID, shipping.Status),
+			ShippingID: shipping.ID,
+			OrderID:    shipping.OrderID,
+			Status:     shipping.Status,
+		})
+		return
 	}
 
-	shipping.Status = ShippingStatusShipped
-	shippings[shippingID] = shipping
-	fmt.Printf("Shipping completed: %s\n", shippingID)
-	return true
+	if !shipping.ReturnToSender {
+		now := time.Now()
+		event := TrackingEvent{
+			Status:      ShippingStatusException,
+			Description: "Return to sender requested",
+			OccurredAt:  now,
+			RecordedAt:  now,
+		}
+		shipping.ReturnToSender = true
+		if shipping.Status == ShippingStatusException {
+			shipping.Events = append(shipping.Events, event)
+			shipping.EstimatedDelivery = nil
+		} else {
+			recordEvent(&shipping, event)
+		}
+		shippings[shipping.ID] = shipping
+	}
+	mu.Unlock()
+
+	resp := ShippingResponse{
+		Success:        true,
+		Message:        "Return to sender requested",
+		ShippingID:     shipping.ID,
+		OrderID:        shipping.OrderID,
+		Type:           shipping.Type,
+		WarehouseID:    shipping.WarehouseID,
+		Carrier:        shipping.Carrier,
+		TrackingNumber: shipping.TrackingNumber,
+		Status:         shipping.Status,
+		ReturnToSender: true,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Return to sender requested: %s for order %s\n", shipping.ID, shipping.OrderID)
+}
+
+func shippingEditable(status string) bool {
+	return status == ShippingStatusPending || status == ShippingStatusLabelCreated
+}
+
+func findActiveShipping(orderID, shippingID string) (Shipping, bool) {
+	for id, s := range shippings {
+		if shippingID != "" && id != shippingID {
+			continue
+		}
+		if shippingID == "" && s.Type != ShippingTypeOutbound {
+			continue
+		}
+		if s.OrderID == orderID && s.Status != ShippingStatusCancelled {
+			return s, true
+		}
+	}
+	return Shipping{}, false
+}
+
+func shippingStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orderID := r.URL.Query().Get("order_id")
+	if orderID == "" {
+		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	shipments := activeOutboundShipments(orderID)
+	if len(shipments) == 0 {
+		var latest Shipping
+		for _, s := range shippings {
+			if s.Type == ShippingTypeOutbound && s.OrderID == orderID && shippingSequence(s.ID) > shippingSequence(latest.ID) {
+				latest = s
+			}
+		}
+		if latest.ID != "" {
+			shipments = append(shipments, latest)
+		}
+	}
+	mu.Unlock()
+
+	if len(shipments) == 0 {
+		http.Error(w, "No shipping found for the order", http.StatusNotFound)
+		return
+	}
+
+	resp := aggregateShippingResponse(orderID, shipments)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func activeOutboundShipments(orderID string) []Shipping {
+	var active []Shipping
+	for _, s := range shippings {
+		if s.Type == ShippingTypeOutbound && s.OrderID == orderID && s.Status != ShippingStatusCancelled && !s.ReturnToSender {
+			active = append(active, s)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return shippingSequence(active[i].ID) < shippingSequence(active[j].ID)
+	})
+	return active
+}
+
+func shippingSequence(shippingID string) int {
+	sequence, err := strconv.Atoi(strings.TrimPrefix(shippingID, "SHP-"))
+	if err != nil {
+		return 0
+	}
+	return sequence
+}
+
+func aggregateShippingResponse(orderID string, shipments []Shipping) ShippingResponse {
+	first := shipments[0]
+	resp := ShippingResponse{
+		Success:        true,
+		ShippingID:     first.ID,
+		OrderID:        orderID,
+		Address:        &first.Address,
+		Carrier:        first.Carrier,
+		ServiceLevel:   first.ServiceLevel,
+		TrackingNumber: first.TrackingNumber,
+		Cost:           &Money{Currency: first.Cost.Currency},
+		Status:         aggregateShippingStatus(shipments),
+	}
+	for _, s := range shipments {
+		resp.WeightGrams += s.WeightGrams
+		resp.Cost.MinorUnits += s.Cost.MinorUnits
+		resp.Shipments = append(resp.Shipments, ShipmentSummary{
+			ShippingID:        s.ID,
+			WarehouseID:       s.WarehouseID,
+			Items:             s.Items,
+			Carrier:           s.Carrier,
+			ServiceLevel:      s.ServiceLevel,
+			TrackingNumber:    s.TrackingNumber,
+			WeightGrams:       s.WeightGrams,
+			Cost:              s.Cost,
+			Status:            s.Status,
+			EstimatedDelivery: s.EstimatedDelivery,
+		})
+		if s.EstimatedDelivery != nil && (resp.EstimatedDelivery == nil || s.EstimatedDelivery.LatestDate > resp.EstimatedDelivery.LatestDate) {
+			resp.EstimatedDelivery = s.EstimatedDelivery
+		}
+	}
+	return resp
+}
+
+func aggregateShippingStatus(shipments []Shipping) string {
+	status := shipments[0].Status
+	same, delivered, returned := true, false, false
+	for _, s := range shipments {
+		switch s.Status {
+		case ShippingStatusException:
+			return ShippingStatusException
+		case ShippingStatusDelivered:
+			delivered = true
+		case ShippingStatusReturned:
+			returned = true
+		}
+		if s.Status != shipments[0].Status {
+			same = false
+		}
+		if shippingProgress[s.Status] < shippingProgress[status] {
+			status = s.Status
+		}
+	}
+
+	switch {
+	case same:
+		return shipments[0].Status
+	case returned:
+		return ShippingStatusException
+	case delivered:
+		return ShippingStatusPartiallyDelivered
+	}
+	return status
+}
+
+func shippingQuotesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	country, ok := address.ResolveCountry(query.Get("country"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unsupported destination country %q", query.Get("country")), http.StatusBadRequest)
+		return
+	}
+
+	weightGrams := 0
+	if raw := query.Get("weight_grams"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			http.Error(w, "Invalid weight_grams parameter", http.StatusBadRequest)
+			return
+		}
+		weightGrams = parsed
+	}
+
+	preference := query.Get("preference")
+	if preference == "" {
+		preference = PreferenceCheapest
+	}
+	if preference != PreferenceCheapest && preference != PreferenceFastest {
+		http.Error(w, "Unsupported carrier preference", http.StatusBadRequest)
+		return
+	}
+
+	rates := quoteRates(weightGrams, country, preference)
+	if len(rates) == 0 {
+		http.Error(w, fmt.Sprintf("%v for %d g to %s", ErrNoRate, weightGrams, country), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ShippingQuotesResponse{
+		Success:     true,
+		WeightGrams: weightGrams,
+		Country:     country,
+		Preference:  preference,
+		Rates:       rates,
+	})
+}
+
+func planShipmentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PlanShipmentsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.OrderID == "" {
+		http.Error(w, "Order ID is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) == 0 {
+		http.Error(w, "At least one item is required", http.StatusBadRequest)
+		return
+	}
+	if err := validateShipmentItems(req.Items); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	normalized, err := address.Normalize(req.Address)
+	if err != nil {
+		writeAddressError(w, "Invalid shipping address", err)
+		return
+	}
+
+	available, err := fetchWarehouseStock()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get warehouse stock: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	planned, err := planShipments(normalized.Country, req.Items, available)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	resp := PlanShipmentsResponse{
+		Success:   true,
+		Message:   fmt.Sprintf("Order split into %d shipment(s)", len(planned)),
+		OrderID:   req.OrderID,
+		Shipments: planned,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	for _, shipment := range planned {
+		fmt.Printf("Planned shipment for order %s from %s: %d item line(s), %d g\n", req.OrderID, shipment.WarehouseID, len(shipment.Items), shipment.WeightGrams)
+	}
+}
+
+func warehousesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WarehousesResponse{Success: true, Warehouses: warehouses})
+}
+
+func planShipments(country string, items []ShipmentItem, available map[string]map[string]int) ([]PlannedShipment, error) {
+	items = mergeShipmentItems(items)
+	ranked := rankWarehouses(country)
+
+	for _, warehouse := range ranked {
+		if checkWarehouseStock(warehouse, items, available[warehouse.ID]) == nil {
+			return []PlannedShipment{plannedShipment(warehouse, items)}, nil
+		}
+	}
+
+	allocations := make(map[string][]ShipmentItem)
+	for _, item := range items {
+		remaining := item.Quantity
+		for _, warehouse := range ranked {
+			if remaining == 0 {
+				break
+			}
+			take := available[warehouse.ID][item.ID]
+			if take > remaining {
+				take = remaining
+			}
+			if take <= 0 {
+				continue
+			}
+			allocations[warehouse.ID] = append(allocations[warehouse.ID], ShipmentItem{ID: item.ID, Quantity: take, WeightGrams: item.WeightGrams})
+			remaining -= take
+		}
+		if remaining > 0 {
+			return nil, fmt.Errorf("%w: %d more of %s needed", ErrInsufficientStock, remaining, item.ID)
+		}
+	}
+
+	var planned []PlannedShipment
+	for _, warehouse := range ranked {
+		if allocated, ok := allocations[warehouse.ID]; ok {
+			planned = append(planned, plannedShipment(warehouse, allocated))
+		}
+	}
+	return planned, nil
+}
+
+func rankWarehouses(country string) []*Warehouse {
+	rank := func(warehouse *Warehouse) int {
+		switch {
+		case warehouse.Country == country:
+			return 0
+		case warehouse.Zone == countryZones[country]:
+			return 1
+		}
+		return 2
+	}
+
+	ranked := make([]*Warehouse, 0, len(warehouses))
+	for i := range warehouses {
+		ranked = append(ranked, &warehouses[i])
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return rank(ranked[i]) < rank(ranked[j])
+	})
+	return ranked
+}
+
+func plannedShipment(warehouse *Warehouse, items []ShipmentItem) PlannedShipment {
+	return PlannedShipment{
+		WarehouseID: warehouse.ID,
+		Origin:      warehouse.Country,
+		Items:       items,
+		WeightGrams: shipmentWeight(items),
+	}
+}
+
+func findWarehouse(warehouseID string) *Warehouse {
+	for i := range warehouses {
+		if warehouses[i].ID == warehouseID {
+			return &warehouses[i]
+		}
+	}
+	return nil
+}
+
+func checkWarehouseStock(warehouse *Warehouse, items []ShipmentItem, stock map[string]int) error {
+	for _, item := range items {
+		if available := stock[item.ID]; available < item.Quantity {
+			return fmt.Errorf("%w: %s has %d of %s, %d required", ErrInsufficientStock, warehouse.ID, available, item.ID, item.Quantity)
+		}
+	}
+	return nil
+}
+
+func fetchWarehouseStock() (map[string]map[string]int, error) {
+	resp, err := http.Get(InventoryServiceURL + "/stock-level")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("inventory service returned %s", resp.Status)
+	}
+
+	var levels StockLevelResponse
+	if err := json.NewDecoder(resp.Body).Decode(&levels); err != nil {
+		return nil, err
+	}
+
+	available := make(map[string]map[string]int)
+	for _, level := range levels.Levels {
+		for _, warehouse := range level.Warehouses {
+			if available[warehouse.WarehouseID] == nil {
+				available[warehouse.WarehouseID] = make(map[string]int)
+			}
+			available[warehouse.WarehouseID][level.ItemID] = warehouse.Available
+		}
+	}
+	return available, nil
+}
+
+func restockWarehouse(shipping Shipping) error {
+	items := make([]map[string]interface{}, 0, len(shipping.Items))
+	for _, item := range shipping.Items {
+		items = append(items, map[string]interface{}{
+			"id":           item.ID,
+			"warehouse_id": shipping.WarehouseID,
+			"quantity":     item.Quantity,
+		})
+	}
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"order_id":  shipping.OrderID,
+		"reference": shipping.ID + "/" + ShippingStatusReturned,
+		"items":     items,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(InventoryServiceURL+"/restock", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("inventory service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func mergeShipmentItems(items []ShipmentItem) []ShipmentItem {
+	merged := make([]ShipmentItem, 0, len(items))
+	index := make(map[string]int)
+	for _, item := range items {
+		if i, ok := index[item.ID]; ok {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+		index[item.ID] = len(merged)
+		merged = append(merged, item)
+	}
+	return merged
+}
+
+func validateShipmentItems(items []ShipmentItem) error {
+	for _, item := range items {
+		if item.ID == "" {
+			return errors.New("Item ID is required")
+		}
+		if item.Quantity <= 0 {
+			return fmt.Errorf("Quantity for item %s must be greater than zero", item.ID)
+		}
+		if item.WeightGrams < 0 {
+			return fmt.Errorf("Weight for item %s must not be negative", item.ID)
+		}
+	}
+	return nil
+}
+
+func shipmentWeight(items []ShipmentItem) int {
+	weight := 0
+	for _, item := range items {
+		weight += item.WeightGrams * item.Quantity
+	}
+	return weight
+}
+
+func quoteRates(weightGrams int, country, preference string) []Rate {
+	var rates []Rate
+	for _, carrier := range carriers {
+		quoted, err := carrier.Quote(weightGrams, country)
+		if err != nil {
+			continue
+		}
+		rates = append(rates, quoted...)
+	}
+	sortRates(rates, preference)
+	return rates
+}
+
+func selectRate(weightGrams int, country, carrierName, serviceLevel, preference string) (Rate, error) {
+	if carrierName == "" {
+		rates := quoteRates(weightGrams, country, preference)
+		if len(rates) == 0 {
+			return Rate{}, fmt.Errorf("%w for %d g to %s", ErrNoRate, weightGrams, country)
+		}
+		return rates[0], nil
+	}
+
+	for _, carrier := range carriers {
+		if carrier.Name() != carrierName {
+			continue
+		}
+		rates, err := carrier.Quote(weightGrams, country)
+		if err != nil {
+			return Rate{}, fmt.Errorf("%s: %w", carrierName, err)
+		}
+		sortRates(rates, preference)
+		for _, rate := range rates {
+			if serviceLevel == "" || rate.ServiceLevel == serviceLevel {
+				return rate, nil
+			}
+		}
+		return Rate{}, fmt.Errorf("Carrier %s has no %s service", carrierName, serviceLevel)
+	}
+	return Rate{}, fmt.Errorf("Unknown carrier %q", carrierName)
+}
+
+func sortRates(rates []Rate, preference string) {
+	sort.SliceStable(rates, func(i, j int) bool {
+		a, b := rates[i], rates[j]
+		if preference == PreferenceFastest && a.MaxDays != b.MaxDays {
+			return a.MaxDays < b.MaxDays
+		}
+		if a.Price.MinorUnits != b.Price.MinorUnits {
+			return a.Price.MinorUnits < b.Price.MinorUnits
+		}
+		return a.MaxDays < b.MaxDays
+	})
+}
+
+func (c rateTableCarrier) Name() string {
+	return c.config.Name
+}
+
+func (c rateTableCarrier) Quote(weightGrams int, country string) ([]Rate, error) {
+	if weightGrams > c.config.MaxWeightGrams {
+		return nil, fmt.Errorf("%w: %d g over %d g", ErrWeightExceeded, weightGrams, c.config.MaxWeightGrams)
+	}
+	if len(c.config.Countries) > 0 {
+		served := false
+		for _, code := range c.config.Countries {
+			if code == country {
+				served = true
+				break
+			}
+		}
+		if !served {
+			return nil, fmt.Errorf("%w %s", ErrCountryNotServed, country)
+		}
+	}
+
+	kilograms := int64((weightGrams + 999) / 1000)
+	if kilograms < 1 {
+		kilograms = 1
+	}
+
+	rates := make([]Rate, 0, len(c.config.Services))
+	for _, service := range c.config.Services {
+		rates = append(rates, Rate{
+			Carrier:      c.config.Name,
+			ServiceLevel: service.Level,
+			Price:        Money{MinorUnits: service.Base + service.PerKg*kilograms, Currency: ShippingCurrency},
+			MinDays:      service.MinDays,
+			MaxDays:      service.MaxDays,
+		})
+	}
+	return rates, nil
+}
+
+func loadCarriers(path string) ([]Carrier, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []CarrierConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	loaded := make([]Carrier, 0, len(configs))
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, errors.New("carrier without name")
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("duplicate carrier %s", config.Name)
+		}
+		names[config.Name] = true
+		if config.MaxWeightGrams <= 0 {
+			return nil, fmt.Errorf("carrier %s: max weight must be greater than zero", config.Name)
+		}
+		for _, code := range config.Countries {
+			if !address.Supported(code) {
+				return nil, fmt.Errorf("carrier %s: unsupported country %s", config.Name, code)
+			}
+		}
+		if len(config.Services) == 0 {
+			return nil, fmt.Errorf("carrier %s: at least one service is required", config.Name)
+		}
+		for _, service := range config.Services {
+			if service.Level == "" {
+				return nil, fmt.Errorf("carrier %s: service without level", config.Name)
+			}
+			if service.Base < 0 || service.PerKg < 0 {
+				return nil, fmt.Errorf("carrier %s: %s prices must not be negative", config.Name, service.Level)
+			}
+			if service.MinDays <= 0 || service.MaxDays < service.MinDays {
+				return nil, fmt.Errorf("carrier %s: %s has an invalid delivery window", config.Name, service.Level)
+			}
+		}
+		loaded = append(loaded, rateTableCarrier{config: config})
+	}
+	return loaded, nil
+}
+
+func loadWarehouses(path string) ([]Warehouse, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded []Warehouse
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for i, warehouse := range loaded {
+		if warehouse.ID == "" {
+			return nil, errors.New("warehouse without id")
+		}
+		if ids[warehouse.ID] {
+			return nil, fmt.Errorf("duplicate warehouse %s", warehouse.ID)
+		}
+		ids[warehouse.ID] = true
+		if !address.Supported(warehouse.Country) {
+			return nil, fmt.Errorf("warehouse %s: unsupported country %s", warehouse.ID, warehouse.Country)
+		}
+		loaded[i].Zone = countryZones[warehouse.Country]
+	}
+	if len(loaded) == 0 {
+		return nil, errors.New("at least one warehouse is required")
+	}
+	return loaded, nil
+}
+
+func loadHolidays(path string) (map[string]map[string]bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var dates map[string][]string
+	if err := json.Unmarshal(data, &dates); err != nil {
+		return nil, err
+	}
+
+	calendar := make(map[string]map[string]bool, len(dates))
+	for country, days := range dates {
+		if !address.Supported(country) {
+			return nil, fmt.Errorf("unsupported country %s", country)
+		}
+		calendar[country] = make(map[string]bool, len(days))
+		for _, day := range days {
+			if _, err := time.Parse(DateFormat, day); err != nil {
+				return nil, fmt.Errorf("%s: invalid date %q", country, day)
+			}
+			calendar[country][day] = true
+		}
+	}
+	return calendar, nil
+}
+
+func simulateShippingProcess() bool {
+	return true
+}
+
+func shipmentHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/shipments/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch parts[1] {
+	case "events":
+		shipmentEventsHandler(w, r, parts[0])
+	case "tracking":
+		shipmentTrackingHandler(w, r, parts[0])
+	case "label":
+		shipmentLabelHandler(w, r, parts[0])
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func shipmentEventsHandler(w http.ResponseWriter, r *http.Request, shippingID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TrackingEventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status == "" {
+		http.Error(w, "Status is required", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	shipping, exists := shippings[shippingID]
+	if !exists {
+		mu.Unlock()
+		http.Error(w, "Shipment not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	event := TrackingEvent{
+		Status:      req.Status,
+		Location:    req.Location,
+		Description: req.Description,
+		OccurredAt:  req.OccurredAt,
+		RecordedAt:  now,
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = now
+	}
+
+	if err := recordEvent(&shipping, event); err != nil {
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ShippingResponse{
+			Success:    false,
+			Message:    err.Error(),
+			ShippingID: shipping.ID,
+			OrderID:    shipping.OrderID,
+			Status:     shipping.Status,
+		})
+		return
+	}
+	if event.Status == ShippingStatusReturned && shipping.WarehouseID != "" {
+		if err := restockWarehouse(shipping); err != nil {
+			mu.Unlock()
+			http.Error(w, fmt.Sprintf("Failed to restock %s: %v", shipping.WarehouseID, err), http.StatusBadGateway)
+			return
+		}
+	}
+	shippings[shipping.ID] = shipping
+	mu.Unlock()
+
+	resp := ShippingResponse{
+		Success:           true,
+		Message:           "Tracking event recorded",
+		ShippingID:        shipping.ID,
+		OrderID:           shipping.OrderID,
+		Type:              shipping.Type,
+		ReturnID:          shipping.ReturnID,
+		Address:           &shipping.Address,
+		Status:            shipping.Status,
+		EstimatedDelivery: shipping.EstimatedDelivery,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	fmt.Printf("Tracking event for %s: %s at %s\n", shipping.ID, event.Status, event.Location)
+}
+
+func shipmentTrackingHandler(w http.ResponseWriter, r *http.Request, shippingID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.Lock()
+	shipping, exists := shippings[shippingID]
+	events := append([]TrackingEvent(nil), shipping.Events...)
+	mu.Unlock()
+	if !exists {
+		http.Error(w, "Shipment not found", http.StatusNotFound)
+		return
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.Before(events[j].OccurredAt)
+	})
+
+	resp := TrackingResponse{
+		Success:           true,
+		ShippingID:        shipping.ID,
+		OrderID:           shipping.OrderID,
+		Type:              shipping.Type,
+		Carrier:           shipping.Carrier,
+		ServiceLevel:      shipping.ServiceLevel,
+		TrackingNumber:    shipping.TrackingNumber,
+		Status:            shipping.Status,
+		ReturnToSender:    shipping.ReturnToSender,
+		EstimatedDelivery: shipping.EstimatedDelivery,
+		Events:            events,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func shipmentLabelHandler(w http.ResponseWriter, r *http.Request, shippingID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mu.Lock()
+	shipping, exists := shippings[shippingID]
+	mu.Unlock()
+	if !exists {
+		http.Error(w, "Shipment not found", http.StatusNotFound)
+		return
+	}
+	if shipping.Label == nil {
+		http.Error(w, fmt.Sprintf("Shipment %s has no label", shippingID), http.StatusNotFound)
+		return
+	}
+	if shipping.Label.Status == LabelStatusVoided {
+		http.Error(w, fmt.Sprintf("Label for shipment %s was voided", shippingID), http.StatusGone)
+		return
+	}
+
+	var path, contentType string
+	switch r.URL.Query().Get("format") {
+	case "", LabelFormatZPL:
+		path, contentType = shipping.Label.ZPLFile, "application/zpl"
+	case LabelFormatPNG:
+		path, contentType = shipping.Label.BarcodeFile, "image/png"
+	default:
+		http.Error(w, "Label format must be zpl or png", http.StatusBadRequest)
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read label: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filepath.Base(path)))
+	w.Write(data)
+}
+
+func writeLabel(shipping Shipping) (*Label, error) {
+	label := &Label{
+		Status:      LabelStatusActive,
+		ZPLFile:     filepath.Join(LabelsDir, shipping.ID+".zpl"),
+		BarcodeFile: filepath.Join(LabelsDir, shipping.ID+".png"),
+		CreatedAt:   time.Now(),
+	}
+
+	if err := ioutil.WriteFile(label.ZPLFile, []byte(renderZPL(shipping)), 0644); err != nil {
+		return nil, err
+	}
+
+	barcode, err := renderCode39(shipping.TrackingNumber)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(label.BarcodeFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := png.Encode(file, barcode); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return label, nil
+}
+
+func renderZPL(shipping Shipping) string {
+	field := strings.NewReplacer("^", "", "~", "")
+	lines := []string{
+		fmt.Sprintf("%s %s", shipping.Carrier, shipping.ServiceLevel),
+		"SHIP TO:",
+		shipping.Address.Line1,
+		shipping.Address.Line2,
+		strings.TrimSpace(fmt.Sprintf("%s %s %s", shipping.Address.City, shipping.Address.Region, shipping.Address.PostalCode)),
+		shipping.Address.Country,
+		fmt.Sprintf("Order %s / %s / %d g", shipping.OrderID, shipping.ID, shipping.WeightGrams),
+	}
+	if shipping.Type == ShippingTypeReturn {
+		lines[1] = fmt.Sprintf("RETURN %s TO:", shipping.ReturnID)
+	}
+
+	var b strings.Builder
+	b.WriteString("^XA\n^CF0,30\n")
+	y := 40
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "^FO50,%d^FD%s^FS\n", y, field.Replace(line))
+		y += 40
+	}
+	fmt.Fprintf(&b, "^BY3,3,120\n^FO50,%d^B3N,N,120,Y,N^FD%s^FS\n", y+20, field.Replace(shipping.TrackingNumber))
+	b.WriteString("^XZ\n")
+	return b.String()
+}
+
+func renderCode39(value string) (image.Image, error) {
+	encoded := "*" + strings.ToUpper(value) + "*"
+
+	var widths []int
+	for i, char := range encoded {
+		pattern, ok := code39Patterns[char]
+		if !ok || (char == '*' && i != 0 && i != len(encoded)-1) {
+			return nil, fmt.Errorf("character %q cannot be encoded in Code 39", char)
+		}
+		if i > 0 {
+			widths = append(widths, BarcodeNarrowWidth)
+		}
+		for _, element := range pattern {
+			if element == 'w' {
+				widths = append(widths, BarcodeWideWidth)
+			} else {
+				widths = append(widths, BarcodeNarrowWidth)
+			}
+		}
+	}
+
+	width := 2 * BarcodeQuietZone
+	for _, w := range widths {
+		width += w
+	}
+	img := image.NewGray(image.Rect(0, 0, width, BarcodeHeight))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	x := BarcodeQuietZone
+	for i, w := range widths {
+		if i%2 == 0 {
+			for dx := 0; dx < w; dx++ {
+				for y := 0; y < BarcodeHeight; y++ {
+					img.SetGray(x+dx, y, color.Gray{Y: 0})
+				}
+			}
+		}
+		x += w
+	}
+	return img, nil
+}
+
+func recordEvent(shipping *Shipping, event TrackingEvent) error {
+	allowed := false
+	for _, next := range shippingTransitions[shipping.Status] {
+		if next == event.Status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s to %s", ErrIllegalTransition, shipping.Status, event.Status)
+	}
+	if shipping.ReturnToSender && (event.Status == ShippingStatusOutForDelivery || event.Status == ShippingStatusDelivered) {
+		return fmt.Errorf("%w: %s cannot be %s", ErrReturningToSender, shipping.ID, event.Status)
+	}
+
+	shipping.Events = append(shipping.Events, event)
+	shipping.Status = event.Status
+	shipping.EstimatedDelivery = estimateDelivery(*shipping)
+	return nil
+}
+
+func estimateDelivery(shipping Shipping) *DeliveryEstimate {
+	if shipping.Type != ShippingTypeOutbound || len(shipping.Events) == 0 {
+		return nil
+	}
+	if shipping.Status == ShippingStatusCancelled || shipping.Status == ShippingStatusReturned || shipping.ReturnToSender {
+		return nil
+	}
+
+	destination := shipping.Address.Country
+	origin := destination
+	if warehouse := findWarehouse(shipping.WarehouseID); warehouse != nil {
+		origin = warehouse.Country
+	}
+	minDays := shipping.MinDays + transitDays(origin, destination)
+	maxDays := shipping.MaxDays + transitDays(origin, destination)
+
+	events := append([]TrackingEvent(nil), shipping.Events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.Before(events[j].OccurredAt)
+	})
+
+	dispatch := nextBusinessDay(events[0].OccurredAt, origin, 1)
+	earliest := addBusinessDays(dispatch, minDays, destination)
+	latest := addBusinessDays(dispatch, maxDays, destination)
+	basis := events[0].Status
+
+	for _, event := range events[1:] {
+		day := event.OccurredAt
+		switch event.Status {
+		case ShippingStatusPickedUp:
+			dispatch = nextBusinessDay(day, origin, 0)
+			earliest = addBusinessDays(dispatch, minDays, destination)
+			latest = addBusinessDays(dispatch, maxDays, destination)
+		case ShippingStatusInTransit:
+			earliest = laterDate(earliest, addBusinessDays(day, 1, destination))
+			latest = laterDate(latest, earliest)
+		case ShippingStatusOutForDelivery:
+			earliest = nextBusinessDay(day, destination, 0)
+			latest = earliest
+		case ShippingStatusDelivered:
+			earliest = day.UTC().Truncate(24 * time.Hour)
+			latest = earliest
+		case ShippingStatusException:
+			earliest = laterDate(earliest, addBusinessDays(day, 1, destination))
+			latest = laterDate(latest, addBusinessDays(day, ExceptionDelayBusinessDays, destination))
+		default:
+			continue
+		}
+		basis = event.Status
+	}
+
+	return &DeliveryEstimate{
+		EarliestDate: earliest.Format(DateFormat),
+		LatestDate:   latest.Format(DateFormat),
+		Basis:        basis,
+		CalculatedAt: time.Now(),
+	}
+}
+
+func transitDays(origin, destination string) int {
+	switch {
+	case origin == destination:
+		return TransitDaysDomestic
+	case countryZones[origin] == countryZones[destination]:
+		return TransitDaysSameZone
+	}
+	return TransitDaysCrossZone
+}
+
+func isBusinessDay(day time.Time, country string) bool {
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false
+	}
+	return !holidays[country][day.Format(DateFormat)]
+}
+
+func nextBusinessDay(day time.Time, country string, skip int) time.Time {
+	day = day.UTC().Truncate(24*time.Hour).AddDate(0, 0, skip)
+	for !isBusinessDay(day, country) {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+func addBusinessDays(day time.Time, days int, country string) time.Time {
+	day = day.UTC().Truncate(24 * time.Hour)
+	for days > 0 {
+		day = day.AddDate(0, 0, 1)
+		if isBusinessDay(day, country) {
+			days--
+		}
+	}
+	return day
+}
+
+func laterDate(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func writeAddressError(w http.ResponseWriter, message string, err error) {
+	resp := AddressValidationResponse{
+		Success: false,
+		Message: fmt.Sprintf("%s: %v", message, err),
+	}
+	var addressErr address.ValidationError
+	if errors.As(err, &addressErr) {
+		resp.Errors = addressErr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(resp)
 }
@@ -6,24 +6,133 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/address"
+	"github.com/122140121-Hamka-RA/saga-order-system-PWL/money"
+)
+
+const (
+	OrchestratorURL     = "http://localhost:8080"
+	OrderServiceURL     = "http://localhost:8081"
+	PaymentServiceURL   = "http://localhost:8082"
+	ShippingServiceURL  = "http://localhost:8083"
+	InventoryServiceURL = "http://localhost:8084"
+	PaymentGatewayURL   = "http://localhost:8090"
 )
 
 const (
-	OrchestratorURL = "http://localhost:8080"
+	TransactionWaitTimeout = 60 * time.Second
+	PollInterval           = 1 * time.Second
 )
 
+type Money = money.Money
+
 type CreateOrderRequest struct {
-	CustomerID string  `json:"customer_id"`
-	Items      []Item  `json:"items"`
-	Amount     float64 `json:"amount"`
-	Address    string  `json:"address"`
+	CustomerID         string   `json:"customer_id"`
+	Items              []Item   `json:"items"`
+	Amount             *Money   `json:"amount,omitempty"`
+	Currency           string   `json:"currency,omitempty"`
+	CouponCode         string   `json:"coupon_code,omitempty"`
+	Address            Address  `json:"address"`
+	BillingAddress     *Address `json:"billing_address,omitempty"`
+	PaymentMethod      string   `json:"payment_method,omitempty"`
+	ShippingPreference string   `json:"shipping_preference,omitempty"`
+}
+
+type AmendOrderRequest struct {
+	OrderID string   `json:"order_id"`
+	Items   []Item   `json:"items,omitempty"`
+	Address *Address `json:"address,omitempty"`
+}
+
+type Address = address.Address
+
+type ReturnOrderRequest struct {
+	OrderID string `json:"order_id"`
+	Items   []Item `json:"items"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type ShippingResponse struct {
+	Success    bool       `json:"success"`
+	Message    string     `json:"message"`
+	ShippingID string     `json:"shipping_id,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	Estimate   *Estimate  `json:"estimated_delivery,omitempty"`
+	Shipments  []Shipment `json:"shipments,omitempty"`
+}
+
+type Estimate struct {
+	EarliestDate string `json:"earliest_date"`
+	LatestDate   string `json:"latest_date"`
+	Basis        string `json:"basis"`
+}
+
+type Shipment struct {
+	ShippingID   string `json:"shipping_id"`
+	WarehouseID  string `json:"warehouse_id"`
+	Carrier      string `json:"carrier"`
+	ServiceLevel string `json:"service_level"`
+	WeightGrams  int    `json:"weight_grams"`
+	Status       string `json:"status,omitempty"`
+}
+
+type StockResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+type StockLevelResponse struct {
+	Success bool         `json:"success"`
+	Levels  []StockLevel `json:"levels"`
+}
+
+type StockLevel struct {
+	ItemID     string           `json:"item_id"`
+	Warehouses []WarehouseLevel `json:"warehouses"`
+}
+
+type WarehouseLevel struct {
+	WarehouseID string `json:"warehouse_id"`
+	OnHand      int    `json:"on_hand"`
+	Reserved    int    `json:"reserved"`
+	Available   int    `json:"available"`
+}
+
+type ShippingQuotesResponse struct {
+	Success bool   `json:"success"`
+	Country string `json:"country"`
+	Rates   []Rate `json:"rates"`
+}
+
+type Rate struct {
+	Carrier      string `json:"carrier"`
+	ServiceLevel string `json:"service_level"`
+	Price        Money  `json:"price"`
+	MinDays      int    `json:"min_days"`
+	MaxDays      int    `json:"max_days"`
+}
+
+type TrackingResponse struct {
+	Success  bool            `json:"success"`
+	Status   string          `json:"status"`
+	Estimate *Estimate       `json:"estimated_delivery,omitempty"`
+	Events   []TrackingEvent `json:"events"`
+}
+
+type TrackingEvent struct {
+	Status      string `json:"status"`
+	Location    string `json:"location,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
 type Item struct {
-	ID       string  `json:"id"`
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Price    *Money `json:"price,omitempty"`
+	Quantity int    `json:"quantity"`
 }
 
 type TransactionResponse struct {
@@ -33,14 +142,70 @@ type TransactionResponse struct {
 }
 
 type Transaction struct {
-	ID            string  `json:"id"`
-	OrderID       string  `json:"order_id"`
-	CustomerID    string  `json:"customer_id"`
-	Amount        float64 `json:"amount"`
-	Address       string  `json:"address"`
-	Status        string  `json:"status"`
-	FailureReason string  `json:"failure_reason,omitempty"`
-	Steps         []Step  `json:"steps"`
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	OrderID       string     `json:"order_id"`
+	CustomerID    string     `json:"customer_id"`
+	Amount        Money      `json:"amount"`
+	PaymentID     string     `json:"payment_id,omitempty"`
+	Address       Address    `json:"address"`
+	Shipments     []Shipment `json:"shipments,omitempty"`
+	Refunds       []Refund   `json:"refunds,omitempty"`
+	Status        string     `json:"status"`
+	Phase         string     `json:"phase,omitempty"`
+	ChildIDs      []string   `json:"child_ids,omitempty"`
+	FailureReason string     `json:"failure_reason,omitempty"`
+	Steps         []Step     `json:"steps"`
+}
+
+type Refund struct {
+	PaymentID string `json:"payment_id"`
+	Amount    Money  `json:"amount"`
+}
+
+type ReconciliationResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Report  struct {
+		TransactionsChecked int                      `json:"transactions_checked"`
+		PaymentsChecked     int                      `json:"payments_checked"`
+		Mismatches          []ReconciliationMismatch `json:"mismatches"`
+	} `json:"report"`
+}
+
+type ReconciliationMismatch struct {
+	Type          string `json:"type"`
+	TransactionID string `json:"transaction_id,omitempty"`
+	OrderID       string `json:"order_id,omitempty"`
+	PaymentID     string `json:"payment_id,omitempty"`
+	Detail        string `json:"detail"`
+}
+
+type Order struct {
+	ID         string         `json:"id"`
+	CustomerID string         `json:"customer_id"`
+	Status     string         `json:"status"`
+	History    []StatusChange `json:"history"`
+}
+
+type StatusChange struct {
+	From   string `json:"from,omitempty"`
+	To     string `json:"to"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type OrderListResponse struct {
+	Success    bool    `json:"success"`
+	Orders     []Order `json:"orders"`
+	Page       int     `json:"page"`
+	PageSize   int     `json:"page_size"`
+	Total      int     `json:"total"`
+	TotalPages int     `json:"total_pages"`
+}
+
+type OrderDetailResponse struct {
+	Success bool  `json:"success"`
+	Order   Order `json:"order"`
 }
 
 type Step struct {
@@ -56,23 +221,94 @@ func main() {
 	fmt.Println("\n=== Running Payment Failure Scenario ===")
 	runPaymentFailureScenario()
 
-	fmt.Println("\n=== Running Shipping Failure Scenario ===")
-	runShippingFailureScenario()
+	fmt.Println("\n=== Running Warehouse Drain Scenario ===")
+	runWarehouseDrainScenario()
+
+	fmt.Println("\n=== Running Card Decline Scenario ===")
+	runCardDeclineScenario()
+
+	fmt.Println("\n=== Running Asynchronous Card Payment Scenario ===")
+	runAsyncCardPaymentScenario()
+
+	fmt.Println("\n=== Running Gateway Timeout Scenario ===")
+	runGatewayTimeoutScenario()
+
+	fmt.Println("\n=== Running Fraud Rejection Scenario ===")
+	runFraudRejectionScenario()
+
+	fmt.Println("\n=== Running Fraud Manual Review Scenario ===")
+	runFraudReviewScenario()
+
+	fmt.Println("\n=== Running Foreign Currency Scenario ===")
+	runForeignCurrencyScenario()
+
+	fmt.Println("\n=== Running Price Mismatch Scenario ===")
+	runPriceMismatchScenario()
+
+	fmt.Println("\n=== Running Coupon Scenario ===")
+	runCouponScenario()
+
+	fmt.Println("\n=== Running Coupon Release Scenario ===")
+	runCouponReleaseScenario()
+
+	fmt.Println("\n=== Running Destination Tax Scenario ===")
+	runDestinationTaxScenario()
+
+	fmt.Println("\n=== Running Out Of Stock Scenario ===")
+	runOutOfStockScenario()
+
+	fmt.Println("\n=== Running Order Amendment Scenario ===")
+	runOrderAmendmentScenario()
+
+	fmt.Println("\n=== Running Return Scenario ===")
+	runReturnScenario()
+
+	fmt.Println("\n=== Running Shipment Tracking Scenario ===")
+	runShipmentTrackingScenario()
+
+	fmt.Println("\n=== Running Carrier Rate Shopping Scenario ===")
+	runCarrierRateShoppingScenario()
+
+	fmt.Println("\n=== Running Address Validation Scenario ===")
+	runAddressValidationScenario()
+
+	fmt.Println("\n=== Running Split Shipment Scenario ===")
+	runSplitShipmentScenario()
+
+	fmt.Println("\n=== Running Competing Order Scenario ===")
+	runCompetingOrderScenario()
+
+	fmt.Println("\n=== Running Amend After Pickup Scenario ===")
+	runAmendAfterPickupScenario()
+
+	fmt.Println("\n=== Running Shipping Sub-Saga Rollback Scenario ===")
+	runSubSagaRollbackScenario()
+
+	fmt.Println("\n=== Running Reconciliation Scenario ===")
+	runReconciliationScenario()
+
+	fmt.Println("\n=== Running Order Status Transition Scenario ===")
+	runOrderStatusTransitionScenario()
+
+	fmt.Println("\n=== Running Order Listing Scenario ===")
+	runOrderListingScenario()
 }
 
 func runSuccessScenario() {
+	topUpWallet("customer-123", usd(50000))
+
 	req := CreateOrderRequest{
 		CustomerID: "customer-123",
 		Items: []Item{
 			{
 				ID:       "item-1",
 				Name:     "Product A",
-				Price:    100.0,
+				Price:    ptr(usd(10000)),
 				Quantity: 2,
 			},
 		},
-		Amount:  200.0,
-		Address: "123 Main St, City, Country",
+		Amount:  ptr(usd(21250)),
+		Address: usAddress("123 Main St"),
 	}
 
 	transactionID := createOrder(req)
@@ -92,12 +328,12 @@ func runPaymentFailureScenario() {
 			{
 				ID:       "item-2",
 				Name:     "Product B",
-				Price:    50.0,
+				Price:    ptr(usd(5000)),
 				Quantity: 1,
 			},
 		},
-		Amount:  0.0,
-		Address: "456 Second St, City, Country",
+		Amount:  ptr(usd(5050)),
+		Address: usAddress("456 Second St"),
 	}
 
 	transactionID := createOrder(req)
@@ -110,19 +346,22 @@ func runPaymentFailureScenario() {
 	checkTransactionStatus(transactionID)
 }
 
-func runShippingFailureScenario() {
+func runWarehouseDrainScenario() {
+	scriptGateway(`[{"operation": "authorize", "behavior": "pending", "delay_ms": 3000, "times": 1}]`)
+
 	req := CreateOrderRequest{
 		CustomerID: "customer-789",
 		Items: []Item{
 			{
 				ID:       "item-3",
 				Name:     "Product C",
-				Price:    150.0,
+				Price:    ptr(usd(15000)),
 				Quantity: 1,
 			},
 		},
-		Amount:  150.0,
-		Address: "",
+		Amount:        ptr(usd(15938)),
+		Address:       usAddress("789 Third St"),
+		PaymentMethod: "CARD",
 	}
 
 	transactionID := createOrder(req)
@@ -131,76 +370,1541 @@ func runShippingFailureScenario() {
 		return
 	}
 
+	fmt.Println("Waiting for the card payment to be pending...")
+	transaction, ok := waitForStep(transactionID, "PROCESS_PAYMENT")
+	if !ok || len(transaction.Shipments) == 0 {
+		fmt.Println("Payment was not processed")
+		return
+	}
+
+	warehouseID := transaction.Shipments[0].WarehouseID
+	fmt.Printf("Draining %s while the payment is pending; the order keeps the stock reserved for it...\n", warehouseID)
+	drainWarehouse("drain-789", warehouseID, "item-3")
+
 	fmt.Println("Waiting for transaction to complete...")
 	checkTransactionStatus(transactionID)
+	printShippingStatus(transaction.OrderID)
+
+	fmt.Println("Releasing the drain reservation...")
+	postJSON(InventoryServiceURL+"/release-stock", map[string]string{"order_id": "drain-789"})
+	warehouseStock(warehouseID, "item-3")
 }
 
-func createOrder(req CreateOrderRequest) string {
-	reqBody, err := json.Marshal(req)
-	if err != nil {
-		fmt.Printf("Error marshaling request: %v\n", err)
-		return ""
+func runCardDeclineScenario() {
+	scriptGateway(`[{"operation": "authorize", "behavior": "decline", "times": 1}]`)
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-321",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Name:     "Product A",
+				Price:    ptr(usd(10000)),
+				Quantity: 1,
+			},
+		},
+		Amount:        ptr(usd(10625)),
+		Address:       usAddress("321 Third St"),
+		PaymentMethod: "CARD",
 	}
 
-	resp, err := http.Post(OrchestratorURL+"/create-order-saga", "application/json", bytes.NewBuffer(reqBody))
-	if err != nil {
-		fmt.Printf("Error sending request: %v\n", err)
-		return ""
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response: %v\n", err)
-		return ""
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+}
+
+func runAsyncCardPaymentScenario() {
+	scriptGateway(`[{"operation": "authorize", "behavior": "pending", "delay_ms": 3000, "times": 1}]`)
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-654",
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Name:     "Product B",
+				Price:    ptr(usd(5000)),
+				Quantity: 2,
+			},
+		},
+		Amount:        ptr(usd(10100)),
+		Address:       usAddress("654 Fourth St"),
+		PaymentMethod: "CARD",
 	}
 
-	var transactionResp TransactionResponse
-	if err := json.Unmarshal(body, &transactionResp); err != nil {
-		fmt.Printf("Error parsing response: %v\n", err)
-		return ""
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
 	}
 
-	if !transactionResp.Success {
-		fmt.Printf("Transaction creation failed: %s\n", transactionResp.Message)
-		return ""
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+}
+
+func runGatewayTimeoutScenario() {
+	scriptGateway(`[{"operation": "capture", "behavior": "timeout", "times": 1}]`)
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-987",
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+		Currency:      "USD",
+		Address:       usAddress("987 Ninth St"),
+		PaymentMethod: "CARD",
 	}
 
-	fmt.Printf("Transaction created: %s\n", transactionResp.Transaction.ID)
-	return transactionResp.Transaction.ID
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for the timed-out capture to fail the transaction...")
+	checkTransactionStatus(transactionID)
+
+	if transaction, ok := getTransaction(transactionID); ok && transaction.OrderID != "" {
+		fmt.Println("Reconciling the failed order, whose capture the gateway applied before timing out...")
+		printReconciliationReport(transaction.OrderID)
+	}
+
+	transactionID = createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok || transaction.OrderID == "" {
+		fmt.Println("Order was not created")
+		return
+	}
+
+	scriptGateway(`[{"operation": "refund", "behavior": "timeout", "times": 1}]`)
+
+	fmt.Println("Returning the order while the gateway times out on the refund...")
+	returnID := startSaga("/return-order-saga", ReturnOrderRequest{
+		OrderID: transaction.OrderID,
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+	})
+	if returnID == "" {
+		fmt.Println("Failed to start return")
+		return
+	}
+
+	fmt.Println("Waiting for return shipment...")
+	checkTransactionStatus(returnID)
+
+	fmt.Println("Receiving returned items at the warehouse...")
+	postJSON(OrchestratorURL+"/receive-return", map[string]string{"transaction_id": returnID})
+
+	fmt.Println("Waiting for return to complete with the refund the gateway applied...")
+	checkTransactionStatus(returnID)
+	printReconciliationReport(transaction.OrderID)
 }
 
-func checkTransactionStatus(transactionID string) {
-	resp, err := http.Get(fmt.Sprintf("%s/transaction-status?transaction_id=%s", OrchestratorURL, transactionID))
-	if err != nil {
-		fmt.Printf("Error getting transaction status: %v\n", err)
+func runFraudRejectionScenario() {
+	postJSON(PaymentServiceURL+"/fraud-blocklist", map[string]interface{}{
+		"type":   "customer",
+		"value":  "customer-999",
+		"reason": "chargeback history",
+	})
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-999",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Name:     "Product A",
+				Price:    ptr(usd(10000)),
+				Quantity: 1,
+			},
+		},
+		Amount:  ptr(usd(10625)),
+		Address: usAddress("999 Ninth St"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("Error reading response: %v\n", err)
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+}
+
+func runFraudReviewScenario() {
+	topUpWallet("customer-777", usd(650000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-777",
+		Items: []Item{
+			{
+				ID:       "item-3",
+				Name:     "Product C",
+				Price:    ptr(usd(15000)),
+				Quantity: 40,
+			},
+		},
+		Amount:         ptr(usd(637500)),
+		Address:        usAddress("777 Seventh St"),
+		BillingAddress: &Address{Line1: "1 Other Rd", City: "Albany", Region: "NY", PostalCode: "12207", Country: "US"},
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
 		return
 	}
 
-	var transactionResp TransactionResponse
-	if err := json.Unmarshal(body, &transactionResp); err != nil {
-		fmt.Printf("Error parsing response: %v\n", err)
+	fmt.Println("Waiting for transaction to reach manual review...")
+	checkTransactionStatus(transactionID)
+
+	fmt.Println("Approving transaction after manual review...")
+	postJSON(OrchestratorURL+"/review-transaction", map[string]interface{}{
+		"transaction_id": transactionID,
+		"approve":        true,
+	})
+	checkTransactionStatus(transactionID)
+}
+
+func runForeignCurrencyScenario() {
+	topUpWallet("customer-808", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-808",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Name:     "Product A",
+				Price:    ptr(eur(9250)),
+				Quantity: 2,
+			},
+		},
+		Amount:  ptr(eur(19656)),
+		Address: usAddress("808 Eighth St"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
 		return
 	}
 
-	fmt.Printf("Transaction ID: %s\n", transactionResp.Transaction.ID)
-	fmt.Printf("Status: %s\n", transactionResp.Transaction.Status)
-	if transactionResp.Transaction.FailureReason != "" {
-		fmt.Printf("Failure Reason: %s\n", transactionResp.Transaction.FailureReason)
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+}
+
+func runPriceMismatchScenario() {
+	topUpWallet("customer-909", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-909",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 3,
+			},
+		},
+		Amount:  ptr(usd(100)),
+		Address: usAddress("909 Ninth St"),
 	}
 
-	fmt.Println("Steps:")
-	for _, step := range transactionResp.Transaction.Steps {
-		fmt.Printf("  - %s: %s\n", step.Name, step.Status)
-		if step.Error != "" {
-			fmt.Printf("    Error: %s\n", step.Error)
-		}
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
 	}
+
+	fmt.Println("Waiting for transaction to fail...")
+	checkTransactionStatus(transactionID)
+
+	fmt.Println("Ordering with an explicit zero item price...")
+	req.Items[0].Price = ptr(usd(0))
+	req.Amount = nil
+	req.Currency = "USD"
+
+	transactionID = createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to fail...")
+	checkTransactionStatus(transactionID)
+}
+
+func runCouponScenario() {
+	topUpWallet("customer-111", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-111",
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Quantity: 3,
+			},
+		},
+		Currency:   "USD",
+		CouponCode: "SAVE10",
+		Address:    usAddress("111 First Ave"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+}
+
+func runCouponReleaseScenario() {
+	req := CreateOrderRequest{
+		CustomerID: "customer-222",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+		},
+		Currency:   "USD",
+		CouponCode: "WELCOME5",
+		Address:    usAddress("222 Second Ave"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to fail and release the coupon...")
+	checkTransactionStatus(transactionID)
+
+	topUpWallet("customer-222", usd(50000))
+
+	transactionID = createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete with the released coupon...")
+	checkTransactionStatus(transactionID)
+}
+
+func runDestinationTaxScenario() {
+	topUpWallet("customer-333", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-333",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+		Amount:  ptr(eur(15930)),
+		Address: Address{Line1: "Hauptstrasse 3", City: "Berlin", PostalCode: "10115", Country: "Germany"},
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+}
+
+func runOutOfStockScenario() {
+	topUpWallet("customer-444", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-444",
+		Items: []Item{
+			{
+				ID:       "item-3",
+				Quantity: 1000,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("444 Fourth Ave"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to fail...")
+	checkTransactionStatus(transactionID)
+}
+
+func runOrderAmendmentScenario() {
+	topUpWallet("customer-555", usd(100000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-555",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("555 Fifth Ave"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok || transaction.OrderID == "" {
+		fmt.Println("Order was not created")
+		return
+	}
+
+	fmt.Println("Amending order to three units and a new address...")
+	amendmentID := amendOrder(AmendOrderRequest{
+		OrderID: transaction.OrderID,
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 3,
+			},
+		},
+		Address: &Address{Line1: "55 Fifth Street", City: "Springfield", Region: "IL", PostalCode: "62704", Country: "US"},
+	})
+	if amendmentID == "" {
+		fmt.Println("Failed to amend order")
+		return
+	}
+
+	fmt.Println("Waiting for amendment to complete...")
+	checkTransactionStatus(amendmentID)
+
+	fmt.Println("Amending order back to one unit, refunding more than the original payment...")
+	amendmentID = amendOrder(AmendOrderRequest{
+		OrderID: transaction.OrderID,
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+		},
+	})
+	if amendmentID == "" {
+		fmt.Println("Failed to amend order")
+		return
+	}
+
+	fmt.Println("Waiting for amendment to complete...")
+	checkTransactionStatus(amendmentID)
+
+	fmt.Println("Amending order to two units, charging the difference again...")
+	amendmentID = amendOrder(AmendOrderRequest{
+		OrderID: transaction.OrderID,
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 2,
+			},
+		},
+	})
+	if amendmentID == "" {
+		fmt.Println("Failed to amend order")
+		return
+	}
+
+	fmt.Println("Waiting for amendment to complete...")
+	checkTransactionStatus(amendmentID)
+
+	fmt.Println("Returning both units, refunding more than the original payment...")
+	returnID := startSaga("/return-order-saga", ReturnOrderRequest{
+		OrderID: transaction.OrderID,
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 2,
+			},
+		},
+	})
+	if returnID == "" {
+		fmt.Println("Failed to start return")
+		return
+	}
+
+	fmt.Println("Waiting for return shipment...")
+	checkTransactionStatus(returnID)
+
+	fmt.Println("Receiving returned items at the warehouse...")
+	postJSON(OrchestratorURL+"/receive-return", map[string]string{"transaction_id": returnID})
+
+	fmt.Println("Waiting for return to complete...")
+	checkTransactionStatus(returnID)
+}
+
+func runReturnScenario() {
+	topUpWallet("customer-666", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-666",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 2,
+			},
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("666 Sixth Ave"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok || transaction.OrderID == "" {
+		fmt.Println("Order was not created")
+		return
+	}
+
+	fmt.Println("Returning one unit of item-1...")
+	returnID := startSaga("/return-order-saga", ReturnOrderRequest{
+		OrderID: transaction.OrderID,
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+		},
+		Reason: "damaged",
+	})
+	if returnID == "" {
+		fmt.Println("Failed to start return")
+		return
+	}
+
+	fmt.Println("Waiting for return shipment...")
+	checkTransactionStatus(returnID)
+
+	fmt.Println("Receiving returned items at the warehouse...")
+	postJSON(OrchestratorURL+"/receive-return", map[string]string{"transaction_id": returnID})
+
+	fmt.Println("Waiting for return to complete...")
+	checkTransactionStatus(returnID)
+
+	fmt.Println("Returning the remaining items but never sending them back...")
+	returnID = startSaga("/return-order-saga", ReturnOrderRequest{
+		OrderID: transaction.OrderID,
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+	})
+	if returnID == "" {
+		fmt.Println("Failed to start return")
+		return
+	}
+
+	fmt.Println("Waiting for return shipment...")
+	checkTransactionStatus(returnID)
+
+	fmt.Println("Cancelling the return...")
+	postJSON(OrchestratorURL+"/cancel-return", map[string]string{"transaction_id": returnID, "reason": "items not received"})
+
+	fmt.Println("Waiting for return to be compensated...")
+	checkTransactionStatus(returnID)
+}
+
+func runShipmentTrackingScenario() {
+	topUpWallet("customer-777", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-777",
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("777 Seventh Ave"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok || transaction.OrderID == "" {
+		fmt.Println("Order was not created")
+		return
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/shipping-status?order_id=%s", ShippingServiceURL, transaction.OrderID))
+	if err != nil {
+		fmt.Printf("Error getting shipping status: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var shipping ShippingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&shipping); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Estimated delivery for %s: %s\n", shipping.ShippingID, shipping.Estimate)
+
+	for _, format := range []string{"zpl", "png"} {
+		fmt.Printf("Label %s for %s: %s\n", format, shipping.ShippingID, fetchLabel(shipping.ShippingID, format))
+	}
+
+	events := []TrackingEvent{
+		{Status: "PICKED_UP", Location: "Warehouse"},
+		{Status: "IN_TRANSIT", Location: "Sorting Hub"},
+		{Status: "OUT_FOR_DELIVERY", Location: "Local Depot"},
+		{Status: "DELIVERED", Location: "777 Seventh Ave"},
+		{Status: "IN_TRANSIT", Location: "Sorting Hub", Description: "should be rejected"},
+	}
+	for _, event := range events {
+		fmt.Printf("Posting tracking event %s for %s: %s\n", event.Status, shipping.ShippingID, postTrackingEvent(shipping.ShippingID, event))
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/shipments/%s/tracking", ShippingServiceURL, shipping.ShippingID))
+	if err != nil {
+		fmt.Printf("Error getting tracking: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var tracking TrackingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tracking); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Shipment %s status: %s\n", shipping.ShippingID, tracking.Status)
+	fmt.Printf("Estimated delivery: %s\n", tracking.Estimate)
+	fmt.Println("Timeline:")
+	for _, event := range tracking.Events {
+		fmt.Printf("  - %s %s\n", event.Status, event.Location)
+	}
+}
+
+func runCarrierRateShoppingScenario() {
+	topUpWallet("customer-888", usd(50000))
+
+	address := Address{Line1: "8 Marina Boulevard", City: "Singapore", PostalCode: "018956", Country: "Singapore"}
+	query := url.Values{}
+	query.Set("country", "SG")
+	query.Set("weight_grams", "2900")
+	query.Set("preference", "FASTEST")
+
+	resp, err := http.Get(ShippingServiceURL + "/shipping-quotes?" + query.Encode())
+	if err != nil {
+		fmt.Printf("Error getting shipping quotes: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var quotes ShippingQuotesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&quotes); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Quotes for 2900 g to %s (fastest first):\n", quotes.Country)
+	for _, rate := range quotes.Rates {
+		fmt.Printf("  - %s %s: %d minor units %s, %d-%d days\n", rate.Carrier, rate.ServiceLevel, rate.Price.MinorUnits, rate.Price.Currency, rate.MinDays, rate.MaxDays)
+	}
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-888",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 2,
+			},
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+		Currency:           "USD",
+		Address:            address,
+		ShippingPreference: "FASTEST",
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+}
+
+func runAddressValidationScenario() {
+	topUpWallet("customer-1010", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-1010",
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+		Currency: "USD",
+		Address:  Address{Line1: "Unter den Linden 1", City: "Berlin", PostalCode: "1011", Country: "DE"},
+	}
+
+	fmt.Println("Creating order with an invalid German postal code...")
+	if transactionID := createOrder(req); transactionID != "" {
+		fmt.Println("Order with an invalid address was accepted")
+		return
+	}
+
+	req.Address = Address{Line1: "  221b   baker street ", City: "LONDON", PostalCode: "nw16xe", Country: "united kingdom"}
+	fmt.Println("Creating order with an unnormalized address...")
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok {
+		return
+	}
+	address := transaction.Address
+	fmt.Printf("Normalized address: %s, %s, %s, %s\n", address.Line1, address.City, address.PostalCode, address.Country)
+}
+
+func runSplitShipmentScenario() {
+	topUpWallet("customer-1111", usd(500000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-1111",
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Quantity: 70,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("1111 Eleventh St"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok || len(transaction.Shipments) == 0 {
+		fmt.Println("Order was not shipped")
+		return
+	}
+	printShippingStatus(transaction.OrderID)
+
+	first := transaction.Shipments[0].ShippingID
+	for _, status := range []string{"PICKED_UP", "IN_TRANSIT", "DELIVERED"} {
+		fmt.Printf("Posting tracking event %s for %s: %s\n", status, first, postTrackingEvent(first, TrackingEvent{Status: status}))
+	}
+	printShippingStatus(transaction.OrderID)
+}
+
+func runCompetingOrderScenario() {
+	topUpWallet("customer-1212", usd(1500000))
+	topUpWallet("customer-1313", usd(500000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-1212",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: warehouseStock("WH-US", "item-1") + 1,
+			},
+		},
+		Currency:       "USD",
+		Address:        usAddress("1212 Twelfth St"),
+		BillingAddress: &Address{Line1: "1 Other Rd", City: "Albany", Region: "NY", PostalCode: "12207", Country: "US"},
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to reach manual review...")
+	checkTransactionStatus(transactionID)
+
+	fmt.Println("Ordering more than the unreserved WH-DE stock while the first order is in review...")
+	drainID := createOrder(CreateOrderRequest{
+		CustomerID: "customer-1313",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: warehouseStock("WH-DE", "item-1") + 1,
+			},
+		},
+		Currency: "EUR",
+		Address:  Address{Line1: "Hauptstrasse 13", City: "Berlin", PostalCode: "10115", Country: "DE"},
+	})
+	if drainID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+	checkTransactionStatus(drainID)
+	if drain, ok := getTransaction(drainID); ok {
+		printShippingStatus(drain.OrderID)
+	}
+
+	fmt.Println("Approving transaction after manual review...")
+	postJSON(OrchestratorURL+"/review-transaction", map[string]interface{}{
+		"transaction_id": transactionID,
+		"approve":        true,
+	})
+
+	fmt.Println("Waiting for transaction to ship from its reserved warehouses...")
+	checkTransactionStatus(transactionID)
+
+	if transaction, ok := getTransaction(transactionID); ok {
+		printShippingStatus(transaction.OrderID)
+	}
+}
+
+func runAmendAfterPickupScenario() {
+	topUpWallet("customer-1414", usd(100000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-1414",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("1414 Fourteenth Ave"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok || transaction.OrderID == "" || len(transaction.Shipments) == 0 {
+		fmt.Println("Order was not shipped")
+		return
+	}
+
+	shippingID := transaction.Shipments[0].ShippingID
+	fmt.Printf("Posting tracking event PICKED_UP for %s: %s\n", shippingID, postTrackingEvent(shippingID, TrackingEvent{Status: "PICKED_UP", Location: "Warehouse"}))
+	fmt.Printf("Cancelling %s after pickup: %s\n", shippingID, cancelShipment(transaction.OrderID, shippingID))
+
+	fmt.Println("Amending order to two units after pickup...")
+	amendmentID := amendOrder(AmendOrderRequest{
+		OrderID: transaction.OrderID,
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 2,
+			},
+		},
+	})
+	if amendmentID == "" {
+		fmt.Println("Failed to amend order")
+		return
+	}
+
+	fmt.Println("Waiting for amendment to complete...")
+	checkTransactionStatus(amendmentID)
+	printShippingStatus(transaction.OrderID)
+}
+
+func runSubSagaRollbackScenario() {
+	scriptGateway(`[{"operation": "authorize", "behavior": "pending", "delay_ms": 3000, "times": 1}]`)
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-1515",
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+		Currency:      "USD",
+		Address:       usAddress("1515 Fifteenth St"),
+		PaymentMethod: "CARD",
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for the card payment to be pending...")
+	transaction, ok := waitForStep(transactionID, "PROCESS_PAYMENT")
+	if !ok {
+		fmt.Println("Payment was not processed")
+		return
+	}
+
+	fmt.Printf("Releasing the stock reservation of %s behind the saga's back...\n", transaction.OrderID)
+	postJSON(InventoryServiceURL+"/release-stock", map[string]string{"order_id": transaction.OrderID})
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+	printShippingStatus(transaction.OrderID)
+}
+
+func runReconciliationScenario() {
+	topUpWallet("customer-1717", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-1717",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("1717 Seventeenth St"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok || transaction.PaymentID == "" {
+		fmt.Println("Order was not paid")
+		return
+	}
+	orphanOrderID := "ORD-ORPHAN-1717"
+
+	fmt.Println("Reconciling the paid order...")
+	printReconciliationReport(transaction.OrderID)
+
+	fmt.Printf("Refunding %s behind the saga's back...\n", transaction.PaymentID)
+	postJSON(PaymentServiceURL+"/refund-payment", map[string]string{
+		"order_id":   transaction.OrderID,
+		"payment_id": transaction.PaymentID,
+	})
+
+	fmt.Printf("Charging customer-1717 for %s, which has no saga...\n", orphanOrderID)
+	postJSON(PaymentServiceURL+"/process-payment", map[string]interface{}{
+		"order_id":    orphanOrderID,
+		"customer_id": "customer-1717",
+		"amount":      usd(1000),
+		"method":      "WALLET",
+	})
+
+	fmt.Println("Reconciling again...")
+	printReconciliationReport(transaction.OrderID, orphanOrderID)
+}
+
+func runOrderStatusTransitionScenario() {
+	topUpWallet("customer-1818", usd(50000))
+
+	req := CreateOrderRequest{
+		CustomerID: "customer-1818",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("1818 Eighteenth St"),
+	}
+
+	transactionID := createOrder(req)
+	if transactionID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(transactionID)
+
+	transaction, ok := getTransaction(transactionID)
+	if !ok {
+		return
+	}
+
+	for _, status := range []string{"PENDING", "SHIPPING"} {
+		fmt.Printf("Moving %s back to %s: %s\n", transaction.OrderID, status, updateOrderStatus(transaction.OrderID, status, "scenario"))
+	}
+	printOrderHistory(transaction.OrderID)
+}
+
+func runOrderListingScenario() {
+	topUpWallet("customer-1919", usd(50000))
+
+	paidID := createOrder(CreateOrderRequest{
+		CustomerID: "customer-1919",
+		Items: []Item{
+			{
+				ID:       "item-1",
+				Quantity: 1,
+			},
+		},
+		Currency: "USD",
+		Address:  usAddress("1919 Nineteenth St"),
+	})
+	if paidID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+	fmt.Println("Waiting for transaction to complete...")
+	checkTransactionStatus(paidID)
+
+	scriptGateway(`[{"operation": "authorize", "behavior": "decline", "times": 1}]`)
+	declinedID := createOrder(CreateOrderRequest{
+		CustomerID: "customer-1919",
+		Items: []Item{
+			{
+				ID:       "item-2",
+				Quantity: 1,
+			},
+		},
+		Currency:      "USD",
+		Address:       usAddress("1919 Nineteenth St"),
+		PaymentMethod: "CARD",
+	})
+	if declinedID == "" {
+		fmt.Println("Failed to create order")
+		return
+	}
+	fmt.Println("Waiting for transaction to fail...")
+	checkTransactionStatus(declinedID)
+
+	for _, query := range []string{
+		"customer_id=customer-1919&page_size=1",
+		"customer_id=customer-1919&page_size=1&page=2",
+		"customer_id=customer-1919&page_size=1&page=3",
+		"customer_id=customer-1919&status=COMPLETED",
+		"customer_id=customer-1919&status=cancelled,completed",
+		"customer_id=customer-1919&page=0",
+		"customer_id=customer-1919&page_size=abc",
+		"customer_id=customer-1919&status=SHIPPED",
+	} {
+		listOrders(query)
+	}
+}
+
+func listOrders(query string) {
+	resp, err := http.Get(OrderServiceURL + "/orders?" + query)
+	if err != nil {
+		fmt.Printf("Error listing orders: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("Error reading response: %v\n", err)
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("GET /orders?%s: %s %s\n", query, resp.Status, bytes.TrimSpace(body))
+		return
+	}
+
+	var listResp OrderListResponse
+	if err := json.Unmarshal(body, &listResp); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return
+	}
+
+	fmt.Printf("GET /orders?%s: page %d of %d (%d per page, %d total)\n", query, listResp.Page, listResp.TotalPages, listResp.PageSize, listResp.Total)
+	for _, order := range listResp.Orders {
+		fmt.Printf("  - %s: %s\n", order.ID, order.Status)
+	}
+}
+
+func updateOrderStatus(orderID, status, reason string) string {
+	reqBody, err := json.Marshal(map[string]string{
+		"order_id": orderID,
+		"status":   status,
+		"reason":   reason,
+	})
+	if err != nil {
+		return err.Error()
+	}
+
+	resp, err := http.Post(OrderServiceURL+"/update-order-status", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+
+	var orderResp struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&orderResp)
+	return fmt.Sprintf("%s %s", resp.Status, orderResp.Message)
+}
+
+func printOrderHistory(orderID string) {
+	resp, err := http.Get(OrderServiceURL + "/orders/" + orderID)
+	if err != nil {
+		fmt.Printf("Error getting order: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var orderResp OrderDetailResponse
+	if err := json.NewDecoder(resp.Body).Decode(&orderResp); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Order %s status: %s\n", orderID, orderResp.Order.Status)
+	fmt.Println("History:")
+	for _, change := range orderResp.Order.History {
+		from := change.From
+		if from == "" {
+			from = "-"
+		}
+		fmt.Printf("  - %s -> %s %s\n", from, change.To, change.Reason)
+	}
+}
+
+func printReconciliationReport(orderIDs ...string) {
+	resp, err := http.Get(OrchestratorURL + "/reconciliation-report")
+	if err != nil {
+		fmt.Printf("Error getting reconciliation report: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var reconciliationResp ReconciliationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&reconciliationResp); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return
+	}
+
+	report := reconciliationResp.Report
+	fmt.Printf("Checked %d transaction(s) and %d payment(s): %s\n", report.TransactionsChecked, report.PaymentsChecked, reconciliationResp.Message)
+	for _, orderID := range orderIDs {
+		matched := true
+		for _, mismatch := range report.Mismatches {
+			if mismatch.OrderID != orderID {
+				continue
+			}
+			matched = false
+			fmt.Printf("  - %s: %s (transaction %s, payment %s) %s\n", orderID, mismatch.Type, mismatch.TransactionID, mismatch.PaymentID, mismatch.Detail)
+		}
+		if matched {
+			fmt.Printf("  - %s: matched\n", orderID)
+		}
+	}
+}
+
+func drainWarehouse(orderID, warehouseID, itemID string) {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"order_id": orderID,
+		"items": []map[string]interface{}{
+			{"id": itemID, "warehouse_id": warehouseID, "quantity": warehouseStock(warehouseID, itemID)},
+		},
+	})
+	if err != nil {
+		fmt.Printf("Error marshaling request: %v\n", err)
+		return
+	}
+
+	resp, err := http.Post(InventoryServiceURL+"/reserve-stock", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Printf("Error sending request: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var stockResp StockResponse
+	json.NewDecoder(resp.Body).Decode(&stockResp)
+	fmt.Printf("Drain reservation: %s %s\n", resp.Status, stockResp.Message)
+}
+
+func waitForStep(transactionID, stepName string) (Transaction, bool) {
+	deadline := time.Now().Add(TransactionWaitTimeout)
+	for time.Now().Before(deadline) {
+		transaction, ok := getTransaction(transactionID)
+		if !ok {
+			return transaction, false
+		}
+		for _, step := range transaction.Steps {
+			if step.Name == stepName && step.Status == "COMPLETED" {
+				return transaction, true
+			}
+		}
+		time.Sleep(PollInterval)
+	}
+	return Transaction{}, false
+}
+
+func cancelShipment(orderID, shippingID string) string {
+	reqBody, err := json.Marshal(map[string]string{
+		"order_id":    orderID,
+		"shipping_id": shippingID,
+	})
+	if err != nil {
+		return err.Error()
+	}
+
+	resp, err := http.Post(ShippingServiceURL+"/cancel-shipping", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+
+	var shippingResp ShippingResponse
+	json.NewDecoder(resp.Body).Decode(&shippingResp)
+	return fmt.Sprintf("%s %s", resp.Status, shippingResp.Message)
+}
+
+func printShippingStatus(orderID string) {
+	resp, err := http.Get(fmt.Sprintf("%s/shipping-status?order_id=%s", ShippingServiceURL, orderID))
+	if err != nil {
+		fmt.Printf("Error getting shipping status: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var shipping ShippingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&shipping); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Shipping status for %s: %s, estimated delivery %s\n", orderID, shipping.Status, shipping.Estimate)
+	for _, shipment := range shipping.Shipments {
+		fmt.Printf("  - %s from %s: %s\n", shipment.ShippingID, shipment.WarehouseID, shipment.Status)
+	}
+}
+
+func warehouseStock(warehouseID, itemID string) int {
+	resp, err := http.Get(fmt.Sprintf("%s/stock-level?item_id=%s", InventoryServiceURL, itemID))
+	if err != nil {
+		fmt.Printf("Error getting stock level: %v\n", err)
+		return 0
+	}
+	defer resp.Body.Close()
+
+	var stockResp StockLevelResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stockResp); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return 0
+	}
+
+	for _, level := range stockResp.Levels {
+		for _, warehouse := range level.Warehouses {
+			if warehouse.WarehouseID == warehouseID {
+				fmt.Printf("Warehouse %s has %d of %s available (%d on hand, %d reserved)\n", warehouseID, warehouse.Available, itemID, warehouse.OnHand, warehouse.Reserved)
+				return warehouse.Available
+			}
+		}
+	}
+	return 0
+}
+
+func fetchLabel(shippingID, format string) string {
+	resp, err := http.Get(fmt.Sprintf("%s/shipments/%s/label?format=%s", ShippingServiceURL, shippingID, format))
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s, %s, %d bytes", resp.Status, resp.Header.Get("Content-Type"), len(body))
+}
+
+func postTrackingEvent(shippingID string, event TrackingEvent) string {
+	reqBody, err := json.Marshal(event)
+	if err != nil {
+		return err.Error()
+	}
+
+	resp, err := http.Post(fmt.Sprintf("%s/shipments/%s/events", ShippingServiceURL, shippingID), "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+
+	return resp.Status
+}
+
+func postJSON(url string, payload interface{}) {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Error marshaling request: %v\n", err)
+		return
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Printf("Error sending request: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+}
+
+func scriptGateway(rules string) {
+	resp, err := http.Post(PaymentGatewayURL+"/script", "application/json", bytes.NewBufferString(rules))
+	if err != nil {
+		fmt.Printf("Error scripting payment gateway: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("Payment gateway scripted: %s\n", rules)
+}
+
+func (e *Estimate) String() string {
+	if e == nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s to %s (%s)", e.EarliestDate, e.LatestDate, e.Basis)
+}
+
+func usAddress(line1 string) Address {
+	return Address{Line1: line1, City: "Springfield", Region: "IL", PostalCode: "62701", Country: "US"}
+}
+
+func usd(minorUnits int64) Money {
+	return Money{MinorUnits: minorUnits, Currency: "USD"}
+}
+
+func eur(minorUnits int64) Money {
+	return Money{MinorUnits: minorUnits, Currency: "EUR"}
+}
+
+func ptr(amount Money) *Money {
+	return &amount
+}
+
+func topUpWallet(customerID string, amount Money) {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"customer_id": customerID,
+		"amount":      amount,
+	})
+	if err != nil {
+		fmt.Printf("Error marshaling request: %v\n", err)
+		return
+	}
+
+	resp, err := http.Post(PaymentServiceURL+"/top-up-wallet", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Printf("Error topping up wallet: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("Wallet topped up: %s with %d minor units of %s\n", customerID, amount.MinorUnits, amount.Currency)
+}
+
+func createOrder(req CreateOrderRequest) string {
+	return startSaga("/create-order-saga", req)
+}
+
+func amendOrder(req AmendOrderRequest) string {
+	return startSaga("/amend-order-saga", req)
+}
+
+func startSaga(path string, payload interface{}) string {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Printf("Error marshaling request: %v\n", err)
+		return ""
+	}
+
+	resp, err := http.Post(OrchestratorURL+path, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		fmt.Printf("Error sending request: %v\n", err)
+		return ""
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("Error reading response: %v\n", err)
+		return ""
+	}
+
+	var transactionResp TransactionResponse
+	if err := json.Unmarshal(body, &transactionResp); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return ""
+	}
+
+	if !transactionResp.Success {
+		fmt.Printf("Transaction creation failed: %s\n", transactionResp.Message)
+		return ""
+	}
+
+	fmt.Printf("Transaction created: %s\n", transactionResp.Transaction.ID)
+	return transactionResp.Transaction.ID
+}
+
+func checkTransactionStatus(transactionID string) {
+	var transaction Transaction
+	deadline := time.Now().Add(TransactionWaitTimeout)
+	for {
+		var ok bool
+		transaction, ok = getTransaction(transactionID)
+		if !ok {
+			return
+		}
+		if transaction.Status != "PENDING" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(PollInterval)
+	}
+
+	fmt.Printf("Transaction ID: %s\n", transaction.ID)
+	fmt.Printf("Status: %s\n", transaction.Status)
+	fmt.Printf("Phase: %s\n", transaction.Phase)
+	if transaction.FailureReason != "" {
+		fmt.Printf("Failure Reason: %s\n", transaction.FailureReason)
+	}
+	for _, shipment := range transaction.Shipments {
+		line := fmt.Sprintf("Shipment from %s: %d g", shipment.WarehouseID, shipment.WeightGrams)
+		if shipment.Carrier != "" {
+			line += fmt.Sprintf(" via %s %s", shipment.Carrier, shipment.ServiceLevel)
+		}
+		if shipment.ShippingID != "" {
+			line += fmt.Sprintf(" (%s)", shipment.ShippingID)
+		}
+		fmt.Println(line)
+	}
+	for _, refund := range transaction.Refunds {
+		fmt.Printf("Refunded %s of %s\n", refund.Amount, refund.PaymentID)
+	}
+
+	fmt.Println("Steps:")
+	for _, step := range transaction.Steps {
+		fmt.Printf("  - %s: %s\n", step.Name, step.Status)
+		if step.Error != "" {
+			fmt.Printf("    Error: %s\n", step.Error)
+		}
+	}
+
+	for _, childID := range transaction.ChildIDs {
+		child, ok := getTransaction(childID)
+		if !ok {
+			continue
+		}
+		fmt.Printf("Child transaction %s (%s): %s\n", child.ID, child.Type, child.Status)
+		if child.FailureReason != "" {
+			fmt.Printf("  Failure Reason: %s\n", child.FailureReason)
+		}
+		for _, step := range child.Steps {
+			fmt.Printf("  - %s: %s\n", step.Name, step.Status)
+		}
+	}
+}
+
+func getTransaction(transactionID string) (Transaction, bool) {
+	resp, err := http.Get(fmt.Sprintf("%s/transaction-status?transaction_id=%s", OrchestratorURL, transactionID))
+	if err != nil {
+		fmt.Printf("Error getting transaction status: %v\n", err)
+		return Transaction{}, false
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Printf("Error reading response: %v\n", err)
+		return Transaction{}, false
+	}
+
+	var transactionResp TransactionResponse
+	if err := json.Unmarshal(body, &transactionResp); err != nil {
+		fmt.Printf("Error parsing response: %v\n", err)
+		return Transaction{}, false
+	}
+
+	return transactionResp.Transaction, true
 }